@@ -0,0 +1,151 @@
+// apps.go - 按应用 (Android UID / 包名) 过滤和匹配流量
+//
+// 流的所属 UID 由宿主应用通过 UIDResolver 查询 (ConnectivityManager.getConnectionOwnerUid，
+// Android 10+ 仅对经过本应用 VPN 的连接有效)，再映射为包名与允许/禁止列表匹配，
+// 包名同时写入连接来源，拦截与限速规则可用 app:<包名> 按应用匹配。
+//
+// 只有能取得应用真实五元组的透明代理连接才查询。引擎不包含 TUN 协议栈，VpnService 中的流量
+// 经本地 SOCKS5/HTTP 入站转发，连接来自回环地址，系统无法据此找到所属应用，不按应用过滤，
+// 此时按应用分流由允许/禁止列表配置 VpnService.Builder 完成。
+package proxyclient
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// 传给 UIDResolver 的协议号 (与 OsConstants.IPPROTO_TCP/IPPROTO_UDP 相同)
+const (
+	IPProtoTCP = 6
+	IPProtoUDP = 17
+)
+
+// uidUnknown 无法确定连接所属应用
+const uidUnknown = -1
+
+// UIDResolver 查询连接所属应用 (供 Java 实现)
+type UIDResolver interface {
+	// GetConnectionOwnerUid 返回连接所属的 UID，找不到时返回 -1
+	GetConnectionOwnerUid(protocol int, srcIP string, srcPort int, dstIP string, dstPort int) int
+	// GetPackagesForUid 返回 UID 对应的包名，多个包名用逗号分隔 (共享 UID)
+	GetPackagesForUid(uid int) string
+}
+
+// appInfo 流所属的应用
+type appInfo struct {
+	uid      int
+	packages []string
+}
+
+func (a appInfo) String() string {
+	if a.uid == uidUnknown {
+		return "未知应用"
+	}
+	owner := strings.Join(a.packages, ",")
+	if owner == "" {
+		owner = "未知包名"
+	}
+	return owner + " (uid " + strconv.Itoa(a.uid) + ")"
+}
+
+// appFilter 查询流所属应用，并按允许/禁止列表过滤
+type appFilter struct {
+	allow    bool // true: 只允许列表中的应用；false: 禁止列表中的应用
+	packages map[string]bool
+	resolver UIDResolver
+
+	mu       sync.Mutex
+	uidNames map[int][]string // UID 对应的包名缓存 (安装/卸载应用后需重启代理)
+}
+
+// newAppFilter 根据允许/禁止列表创建过滤器，未设置查询接口时返回 nil。
+// 两个列表都为空时只查询所属应用 (用于规则匹配)，不拒绝任何流
+func newAppFilter(allowed, disallowed []string, resolver UIDResolver) (*appFilter, error) {
+	if len(allowed) > 0 && len(disallowed) > 0 {
+		return nil, errors.New("不能同时设置应用允许列表和禁止列表")
+	}
+	if resolver == nil {
+		return nil, nil
+	}
+	list := allowed
+	if len(list) == 0 {
+		list = disallowed
+	}
+	filter := &appFilter{
+		allow:    len(allowed) > 0,
+		packages: make(map[string]bool, len(list)),
+		resolver: resolver,
+		uidNames: make(map[int][]string),
+	}
+	for _, name := range list {
+		filter.packages[name] = true
+	}
+	return filter, nil
+}
+
+// packagesForUID 返回 UID 对应的包名
+func (f *appFilter) packagesForUID(uid int) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if names, ok := f.uidNames[uid]; ok {
+		return names
+	}
+	names := splitList(f.resolver.GetPackagesForUid(uid))
+	f.uidNames[uid] = names
+	return names
+}
+
+// lookup 查询从 src 到 dst 的流所属的应用
+func (f *appFilter) lookup(protocol int, src, dst net.Addr) appInfo {
+	srcIP, srcPort := splitAddr(src)
+	dstIP, dstPort := splitAddr(dst)
+	uid := f.resolver.GetConnectionOwnerUid(protocol, srcIP, srcPort, dstIP, dstPort)
+	if uid < 0 {
+		return appInfo{uid: uidUnknown}
+	}
+	return appInfo{uid: uid, packages: f.packagesForUID(uid)}
+}
+
+// allows 判断应用是否允许使用代理。
+// 无法确定所属应用时，允许列表模式拒绝，禁止列表模式放行。
+func (f *appFilter) allows(app appInfo) bool {
+	if len(f.packages) == 0 {
+		return true
+	}
+	for _, name := range app.packages {
+		if f.packages[name] {
+			return f.allow
+		}
+	}
+	return !f.allow
+}
+
+// identifyApp 查询从 from 到 to 的流所属应用，记入 src 并按应用列表过滤，返回是否允许。
+// 未设置查询接口时原样返回 src。
+func (c *ProxyClient) identifyApp(src connSource, protocol int, from, to net.Addr) (connSource, bool) {
+	if c.apps == nil {
+		return src, true
+	}
+	app := c.apps.lookup(protocol, from, to)
+	src.app = strings.Join(app.packages, ",")
+	if !c.apps.allows(app) {
+		c.logInfo("入站 [%s] 拒绝应用 %s: %s -> %s", src.inbound, app, from, to)
+		return src, false
+	}
+	return src, true
+}
+
+// splitAddr 返回 TCP/UDP 地址的 IP 和端口，其它地址返回空值
+func splitAddr(addr net.Addr) (string, int) {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.IP.String(), a.Port
+	case *net.UDPAddr:
+		return a.IP.String(), a.Port
+	}
+	return "", 0
+}
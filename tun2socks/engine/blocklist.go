@@ -0,0 +1,253 @@
+// blocklist.go - 域名/IP 拦截列表 (广告、跟踪器过滤)
+//
+// 支持的规则格式 (可混合在同一文件中)：
+//
+//	0.0.0.0 ads.example.com    hosts 格式，只拦截该域名
+//	||tracker.example.com^     AdGuard/ABP 域名规则，拦截该域名及其子域名
+//	@@||cdn.example.com^       例外规则，优先于拦截规则
+//	example.net                纯域名，拦截该域名及其子域名
+//	198.51.100.0/24            IP 地址或地址段
+//	inbound:guest example.net  以 inbound:<标签>、app:<包名> 开头的规则只对该入站或应用的连接生效
+//
+// 以 #、! 开头的行以及 [Adblock Plus 2.0] 之类的头部为注释。带有 $ 修饰符 (除 $important)、
+// 路径或通配符的 ABP 规则只对浏览器有意义，加载时忽略。
+package proxyclient
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+)
+
+// hostsSinkNames hosts 文件中指向本机的常见条目，不作为拦截规则
+var hostsSinkNames = map[string]bool{
+	"localhost":             true,
+	"localhost.localdomain": true,
+	"local":                 true,
+	"broadcasthost":         true,
+	"ip6-localhost":         true,
+	"ip6-loopback":          true,
+	"ip6-localnet":          true,
+	"ip6-mcastprefix":       true,
+	"ip6-allnodes":          true,
+	"ip6-allrouters":        true,
+	"ip6-allhosts":          true,
+	"0.0.0.0":               true,
+}
+
+// blocklist 已加载的拦截规则
+type blocklist struct {
+	exact      map[string]struct{} // 只拦截域名本身 (hosts 格式)
+	suffix     map[string]struct{} // 拦截域名及其子域名
+	exceptions map[string]struct{} // 例外，域名及其子域名
+	prefixes   []netip.Prefix
+	scoped     map[ruleScope]*blocklist // 只对部分入站生效的规则，与全局规则分别匹配
+}
+
+func newBlocklist() *blocklist {
+	return &blocklist{
+		exact:      make(map[string]struct{}),
+		suffix:     make(map[string]struct{}),
+		exceptions: make(map[string]struct{}),
+	}
+}
+
+// loadBlocklist 从文件和内联规则加载拦截列表，没有任何规则时返回 nil
+func loadBlocklist(files, rules []string) (*blocklist, error) {
+	list := newBlocklist()
+	for _, path := range files {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("读取拦截列表失败: %w", err)
+		}
+		err = list.parse(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("解析拦截列表 %s 失败: %w", path, err)
+		}
+	}
+	for _, rule := range rules {
+		list.addRule(rule)
+	}
+	if list.empty() {
+		return nil, nil
+	}
+	return list, nil
+}
+
+func (b *blocklist) empty() bool {
+	return b.size() == 0
+}
+
+// size 规则条数 (不含例外)
+func (b *blocklist) size() int {
+	n := len(b.exact) + len(b.suffix) + len(b.prefixes)
+	for _, list := range b.scoped {
+		n += list.size()
+	}
+	return n
+}
+
+func (b *blocklist) parse(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		b.addRule(scanner.Text())
+	}
+	return scanner.Err()
+}
+
+// addRule 解析一行规则，无法识别的行直接忽略
+func (b *blocklist) addRule(line string) {
+	line = strings.TrimSpace(line)
+	if line == "" || line[0] == '#' || line[0] == '!' || line[0] == '[' {
+		return
+	}
+	scope, line, err := parseRuleScope(line)
+	if err != nil {
+		return
+	}
+	if scope != (ruleScope{}) {
+		if b.scoped == nil {
+			b.scoped = make(map[ruleScope]*blocklist)
+		}
+		if b.scoped[scope] == nil {
+			b.scoped[scope] = newBlocklist()
+		}
+		b.scoped[scope].addRule(line)
+		return
+	}
+
+	// AdGuard/ABP 域名规则
+	if strings.HasPrefix(line, "||") || strings.HasPrefix(line, "@@||") {
+		exception := strings.HasPrefix(line, "@@")
+		rule := strings.TrimPrefix(strings.TrimPrefix(line, "@@"), "||")
+		if i := strings.IndexByte(rule, '$'); i >= 0 {
+			if rule[i+1:] != "important" {
+				return
+			}
+			rule = rule[:i]
+		}
+		rule = strings.TrimSuffix(rule, "^")
+		domain := validDomain(rule)
+		if domain == "" {
+			return
+		}
+		if exception {
+			b.exceptions[domain] = struct{}{}
+		} else {
+			b.suffix[domain] = struct{}{}
+		}
+		return
+	}
+
+	// 去掉行尾注释
+	if i := strings.IndexByte(line, '#'); i >= 0 {
+		line = strings.TrimSpace(line[:i])
+	}
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return
+	}
+
+	// hosts 格式: IP 域名 [域名...]
+	if len(fields) > 1 {
+		if net.ParseIP(fields[0]) == nil {
+			return
+		}
+		for _, name := range fields[1:] {
+			if hostsSinkNames[strings.ToLower(name)] {
+				continue
+			}
+			if domain := validDomain(name); domain != "" {
+				b.exact[domain] = struct{}{}
+			}
+		}
+		return
+	}
+
+	// 单独的 IP、地址段或域名
+	if prefix, err := netip.ParsePrefix(line); err == nil {
+		b.prefixes = append(b.prefixes, prefix.Masked())
+		return
+	}
+	if addr, err := netip.ParseAddr(line); err == nil {
+		b.prefixes = append(b.prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+		return
+	}
+	if domain := validDomain(line); strings.Contains(domain, ".") {
+		b.suffix[domain] = struct{}{}
+	}
+}
+
+// blocks 来自 src 的连接的目标 host 是否被拦截 (host 为域名或 IP 地址，不含端口)
+func (b *blocklist) blocks(host string, src connSource) bool {
+	if b.blocksHost(host) {
+		return true
+	}
+	for scope, list := range b.scoped {
+		if scope.matches(src) && list.blocksHost(host) {
+			return true
+		}
+	}
+	return false
+}
+
+// blocksHost 按本列表的规则判断 host 是否被拦截
+func (b *blocklist) blocksHost(host string) bool {
+	if addr, err := netip.ParseAddr(strings.Trim(host, "[]")); err == nil {
+		addr = addr.Unmap()
+		for _, prefix := range b.prefixes {
+			if prefix.Contains(addr) {
+				return true
+			}
+		}
+		return false
+	}
+
+	domain := strings.TrimSuffix(strings.ToLower(host), ".")
+	if matchDomainSuffix(b.exceptions, domain) {
+		return false
+	}
+	if _, ok := b.exact[domain]; ok {
+		return true
+	}
+	return matchDomainSuffix(b.suffix, domain)
+}
+
+// matchDomainSuffix domain 本身或其任一上级域名是否在集合中
+func matchDomainSuffix(set map[string]struct{}, domain string) bool {
+	if len(set) == 0 {
+		return false
+	}
+	for {
+		if _, ok := set[domain]; ok {
+			return true
+		}
+		i := strings.IndexByte(domain, '.')
+		if i < 0 {
+			return false
+		}
+		domain = domain[i+1:]
+	}
+}
+
+// checkBlocked 来自 src 的连接的目标 (host:port) 被拦截时返回 ErrorCodeBlocked 错误
+func (c *ProxyClient) checkBlocked(target string, src connSource) error {
+	if c.blocklist == nil {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(target)
+	if err != nil {
+		host = target
+	}
+	if !c.blocklist.blocks(host, src) {
+		return nil
+	}
+	c.stats.blocked.Add(1)
+	return newTunnelError(ErrorCodeBlocked, fmt.Errorf("目标 %s 已被拦截列表拦截", host))
+}
@@ -0,0 +1,75 @@
+// proxyserver - 自建隧道服务端
+//
+// 生成 ECH 密钥 (输出的 ECHConfigList 填入客户端配置或 DNS HTTPS 记录)：
+//
+//	proxyserver -gen-ech public.example.com -ech-key ech.pem
+//
+// 启动服务端：
+//
+//	proxyserver -listen :443 -token secret -cert cert.pem -key key.pem -ech-key ech.pem
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/yourusername/proxyclient/server"
+)
+
+func main() {
+	listenAddr := flag.String("listen", ":443", "监听地址")
+	path := flag.String("path", "/", "WebSocket 路径")
+	token := flag.String("token", "", "认证令牌 (为空则不认证)")
+	certFile := flag.String("cert", "", "TLS 证书文件 (为空则使用明文 HTTP)")
+	keyFile := flag.String("key", "", "TLS 私钥文件")
+	echKeyFiles := flag.String("ech-key", "", "ECH 密钥文件，多个用逗号分隔")
+	genECH := flag.String("gen-ech", "", "为指定的 public name 生成 ECH 密钥并写入 -ech-key 后退出")
+	v0Only := flag.Bool("v0-only", false, "只使用 v0 文本协议")
+	flag.Parse()
+
+	if *genECH != "" {
+		if *echKeyFiles == "" || strings.Contains(*echKeyFiles, ",") {
+			log.Fatal("生成 ECH 密钥需要通过 -ech-key 指定一个输出文件")
+		}
+		key, err := server.GenerateECHKey(*genECH, 0)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := server.SaveECHKey(*echKeyFiles, key); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(key.ConfigListBase64())
+		return
+	}
+
+	config := server.Config{
+		Path:      *path,
+		Token:     *token,
+		CertFile:  *certFile,
+		KeyFile:   *keyFile,
+		DisableV1: *v0Only,
+	}
+	for _, f := range strings.Split(*echKeyFiles, ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			config.ECHKeyFiles = append(config.ECHKeyFiles, f)
+		}
+	}
+
+	srv, err := server.NewServer(config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := srv.Start(*listenAddr); err != nil {
+		log.Fatal(err)
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	<-sig
+	srv.Stop()
+}
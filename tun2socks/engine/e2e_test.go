@@ -0,0 +1,443 @@
+package proxyclient_test
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	proxyclient "github.com/yourusername/proxyclient"
+	"github.com/yourusername/proxyclient/server"
+)
+
+const e2eToken = "e2e-token"
+
+// selfSignedCert 生成 127.0.0.1 的自签名证书，返回证书与 PEM
+func selfSignedCert(t *testing.T) (tls.Certificate, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, string(certPEM)
+}
+
+// testLogger 把日志写入 t。Stop 不等待正在处理的连接结束，测试返回后仍可能有日志，
+// 清理开始后不再写入 t
+func testLogger(t *testing.T, name string) func(level, message string) {
+	var mu sync.Mutex
+	stopped := false
+	t.Cleanup(func() {
+		mu.Lock()
+		defer mu.Unlock()
+		stopped = true
+	})
+	return func(level, message string) {
+		mu.Lock()
+		defer mu.Unlock()
+		if !stopped {
+			t.Logf("[%s %s] %s", name, level, message)
+		}
+	}
+}
+
+// startServer 在 127.0.0.1 上启动隧道服务端
+func startServer(t *testing.T, cert tls.Certificate, disableV1 bool) string {
+	t.Helper()
+	srv, err := server.NewServer(server.Config{
+		Path:         "/ws",
+		Token:        e2eToken,
+		Certificates: []tls.Certificate{cert},
+		DisableV1:    disableV1,
+		DialTimeout:  2 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.SetLogCallback(testLogger(t, "server"))
+	if err := srv.Start("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { srv.Stop() })
+	return srv.Addr().String()
+}
+
+// startClient 启动连接 serverAddr 的客户端，返回本地 SOCKS5/HTTP 监听地址
+func startClient(t *testing.T, serverAddr, caPEM, token, protocol string) (*proxyclient.ProxyClient, string) {
+	t.Helper()
+	client, err := proxyclient.NewProxyClient(proxyclient.Config{
+		ServerAddr: serverAddr + "/ws",
+		Token:      token,
+		CACertPEM:  caPEM,
+		CAOnly:     true,
+		ECHPolicy:  proxyclient.ECHPolicyDisable,
+		Protocol:   protocol,
+		MaxRetries: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.SetLogCallback(testLogger(t, "client"))
+
+	listenAddr := freeAddr(t)
+	if err := client.Start(listenAddr); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Stop() })
+	return client, listenAddr
+}
+
+// freeAddr 返回一个空闲的本地 TCP 地址
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().String()
+}
+
+// startEcho 启动 TCP 回显服务
+func startEcho(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// startUpgradeEcho 启动接受协议升级的服务：读取请求后回复 101，之后回显，请求行写入 requestLines
+func startUpgradeEcho(t *testing.T) (string, <-chan string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	requestLines := make(chan string, 16)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				req, err := http.ReadRequest(reader)
+				if err != nil {
+					return
+				}
+				requestLines <- req.Method + " " + req.RequestURI
+				fmt.Fprintf(conn, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: %s\r\nConnection: Upgrade\r\n\r\n", req.Header.Get("Upgrade"))
+				io.Copy(conn, reader)
+			}()
+		}
+	}()
+	return ln.Addr().String(), requestLines
+}
+
+// closedAddr 返回一个没有监听的本地地址，连接会被拒绝
+func closedAddr(t *testing.T) string {
+	t.Helper()
+	return freeAddr(t)
+}
+
+// socks5Connect 通过 SOCKS5 代理连接 IPv4 目标，返回连接与应答码
+func socks5Connect(t *testing.T, proxyAddr, target string) (net.Conn, byte) {
+	t.Helper()
+	conn, err := net.DialTimeout("tcp", proxyAddr, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	host, portStr, _ := net.SplitHostPort(target)
+	port, _ := strconv.Atoi(portStr)
+	request := append([]byte{0x05, 0x01, 0x00, 0x01}, net.ParseIP(host).To4()...)
+	request = binary.BigEndian.AppendUint16(request, uint16(port))
+
+	reply := make([]byte, 10)
+	if _, err := conn.Write([]byte{0x05, 0x01, 0x00}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(conn, reply[:2]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Write(request); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		t.Fatalf("读取 SOCKS5 应答: %v", err)
+	}
+	return conn, reply[1]
+}
+
+// httpConnect 通过 HTTP CONNECT 连接目标，返回连接与响应
+func httpConnect(t *testing.T, proxyAddr, target string) (net.Conn, *bufio.Reader, *http.Response) {
+	t.Helper()
+	conn, err := net.DialTimeout("tcp", proxyAddr, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\n", target, target)
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, &http.Request{Method: http.MethodConnect})
+	if err != nil {
+		t.Fatalf("读取 CONNECT 响应: %v", err)
+	}
+	return conn, reader, resp
+}
+
+// assertEcho 发送含 '|' 与非 UTF-8 字节的数据并检查回显
+func assertEcho(t *testing.T, w io.Writer, r io.Reader) {
+	t.Helper()
+	payload := []byte("CONNECT:x|\x00\xff\xfeCLOSE")
+	if _, err := w.Write(payload); err != nil {
+		t.Fatal(err)
+	}
+	got := make([]byte, len(payload))
+	if _, err := io.ReadFull(r, got); err != nil {
+		t.Fatalf("读取回显: %v", err)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Errorf("回显 %q，应为 %q", got, payload)
+	}
+}
+
+func TestEndToEnd(t *testing.T) {
+	cert, caPEM := selfSignedCert(t)
+	echoAddr := startEcho(t)
+	web := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s %s", r.Method, r.URL.Path)
+	}))
+	defer web.Close()
+	upgradeAddr, upgradeRequests := startUpgradeEcho(t)
+
+	cases := []struct {
+		protocol  string
+		disableV1 bool
+	}{
+		{proxyclient.ProtocolV0, false},
+		{proxyclient.ProtocolV1, false},
+		{proxyclient.ProtocolAuto, false},
+		{proxyclient.ProtocolAuto, true}, // 服务端只支持 v0 时回退
+	}
+	for _, tc := range cases {
+		name := tc.protocol
+		if tc.disableV1 {
+			name += "-server-v0"
+		}
+		t.Run(name, func(t *testing.T) {
+			serverAddr := startServer(t, cert, tc.disableV1)
+			client, proxyAddr := startClient(t, serverAddr, caPEM, e2eToken, tc.protocol)
+
+			t.Run("socks5", func(t *testing.T) {
+				conn, reply := socks5Connect(t, proxyAddr, echoAddr)
+				defer conn.Close()
+				if reply != 0x00 {
+					t.Fatalf("SOCKS5 应答 0x%02x，应为成功", reply)
+				}
+				assertEcho(t, conn, conn)
+			})
+
+			t.Run("http-connect", func(t *testing.T) {
+				conn, reader, resp := httpConnect(t, proxyAddr, echoAddr)
+				defer conn.Close()
+				if resp.StatusCode != http.StatusOK {
+					t.Fatalf("CONNECT 响应 %s，应为 200", resp.Status)
+				}
+				assertEcho(t, conn, reader)
+			})
+
+			t.Run("http-forward", func(t *testing.T) {
+				httpClient := &http.Client{
+					Transport: &http.Transport{Proxy: http.ProxyURL(&url.URL{Scheme: "http", Host: proxyAddr})},
+					Timeout:   10 * time.Second,
+				}
+				defer httpClient.CloseIdleConnections()
+				resp, err := httpClient.Get(web.URL + "/hello")
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer resp.Body.Close()
+				body, _ := io.ReadAll(resp.Body)
+				if resp.StatusCode != http.StatusOK || string(body) != "GET /hello" {
+					t.Errorf("转发响应 %s %q", resp.Status, body)
+				}
+			})
+
+			// ws:// 等绝对 URI 的升级请求经原始隧道转发，目标收到 origin-form 的请求行
+			t.Run("http-upgrade", func(t *testing.T) {
+				conn, err := net.DialTimeout("tcp", proxyAddr, 5*time.Second)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer conn.Close()
+				conn.SetDeadline(time.Now().Add(10 * time.Second))
+				fmt.Fprintf(conn, "GET http://%s/chat HTTP/1.1\r\nHost: %s\r\nConnection: Upgrade\r\nUpgrade: websocket\r\nProxy-Connection: keep-alive\r\n\r\n", upgradeAddr, upgradeAddr)
+				reader := bufio.NewReader(conn)
+				resp, err := http.ReadResponse(reader, nil)
+				if err != nil {
+					t.Fatalf("读取升级响应: %v", err)
+				}
+				if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Upgrade") != "websocket" {
+					t.Fatalf("升级响应 %s (Upgrade: %q)", resp.Status, resp.Header.Get("Upgrade"))
+				}
+				if line := <-upgradeRequests; line != "GET /chat" {
+					t.Errorf("目标收到请求行 %q，应为 \"GET /chat\"", line)
+				}
+				assertEcho(t, conn, reader)
+			})
+
+			// 目标拒绝连接：服务端返回 ERROR 帧 (v1 携带错误码，v0 按错误信息分类)
+			t.Run("target-refused", func(t *testing.T) {
+				target := closedAddr(t)
+				conn, reply := socks5Connect(t, proxyAddr, target)
+				conn.Close()
+				if reply != 0x05 {
+					t.Errorf("SOCKS5 应答 0x%02x，应为 0x05 (connection refused)", reply)
+				}
+				if code := proxyclient.ErrorCodeOf(client.LastError()); code != proxyclient.ErrorCodeTargetRefused {
+					t.Errorf("错误码 %d，应为 %d (%v)", code, proxyclient.ErrorCodeTargetRefused, client.LastError())
+				}
+
+				conn, _, resp := httpConnect(t, proxyAddr, target)
+				body, _ := io.ReadAll(resp.Body)
+				conn.Close()
+				if resp.StatusCode != http.StatusBadGateway {
+					t.Errorf("CONNECT 响应 %s，应为 502", resp.Status)
+				}
+				// 正文只有错误码与说明，不泄露服务端的错误详情
+				if want := fmt.Sprintf("代理错误 %d: %s\n", proxyclient.ErrorCodeTargetRefused,
+					proxyclient.ErrorCodeDescription(proxyclient.ErrorCodeTargetRefused)); string(body) != want {
+					t.Errorf("CONNECT 响应正文 %q，应为 %q", body, want)
+				}
+			})
+		})
+	}
+}
+
+func TestEndToEndAuthFailed(t *testing.T) {
+	cert, caPEM := selfSignedCert(t)
+	echoAddr := startEcho(t)
+	serverAddr := startServer(t, cert, false)
+
+	for _, protocol := range []string{proxyclient.ProtocolV0, proxyclient.ProtocolV1, proxyclient.ProtocolAuto} {
+		t.Run(protocol, func(t *testing.T) {
+			client, proxyAddr := startClient(t, serverAddr, caPEM, "wrong-token", protocol)
+
+			conn, reply := socks5Connect(t, proxyAddr, echoAddr)
+			conn.Close()
+			if reply != 0x02 {
+				t.Errorf("SOCKS5 应答 0x%02x，应为 0x02 (not allowed)", reply)
+			}
+			if code := proxyclient.ErrorCodeOf(client.LastError()); code != proxyclient.ErrorCodeAuthFailed {
+				t.Errorf("错误码 %d，应为 %d (%v)", code, proxyclient.ErrorCodeAuthFailed, client.LastError())
+			}
+
+			conn, _, resp := httpConnect(t, proxyAddr, echoAddr)
+			conn.Close()
+			if resp.StatusCode != http.StatusForbidden {
+				t.Errorf("CONNECT 响应 %s，应为 403", resp.Status)
+			}
+		})
+	}
+}
+
+func TestNetworkChangeProbe(t *testing.T) {
+	cert, caPEM := selfSignedCert(t)
+	serverAddr := startServer(t, cert, false)
+
+	// waitProbes 等待探测次数达到 n
+	waitProbes := func(t *testing.T, client *proxyclient.ProxyClient, n int64) proxyclient.Stats {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			stats := client.Stats()
+			if stats.NetworkProbes >= n {
+				return stats
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("网络切换后未探测服务端: %+v", stats)
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	}
+
+	t.Run("reachable", func(t *testing.T) {
+		client, _ := startClient(t, serverAddr, caPEM, e2eToken, proxyclient.ProtocolAuto)
+		client.OnNetworkChanged("wifi", "1") // 第一次通知只记录网络
+		client.OnNetworkChanged(proxyclient.NetworkNone, "")
+		time.Sleep(100 * time.Millisecond)
+		if probes := client.Stats().NetworkProbes; probes != 0 {
+			t.Errorf("断网时不应探测，已探测 %d 次", probes)
+		}
+
+		client.OnNetworkChanged("cellular", "2")
+		stats := waitProbes(t, client, 1)
+		if stats.NetworkProbeFailures != 0 {
+			t.Errorf("服务端可达，探测却失败: %v", client.LastError())
+		}
+	})
+
+	t.Run("auth-failed", func(t *testing.T) {
+		client, _ := startClient(t, serverAddr, caPEM, "wrong-token", proxyclient.ProtocolAuto)
+		client.OnNetworkChanged("wifi", "1")
+		client.OnNetworkChanged("cellular", "2")
+		stats := waitProbes(t, client, 1)
+		if stats.NetworkProbeFailures != 1 {
+			t.Errorf("探测失败次数 %d，应为 1", stats.NetworkProbeFailures)
+		}
+		if code := proxyclient.ErrorCodeOf(client.LastError()); code != proxyclient.ErrorCodeAuthFailed {
+			t.Errorf("错误码 %d，应为 %d (%v)", code, proxyclient.ErrorCodeAuthFailed, client.LastError())
+		}
+	})
+}
@@ -0,0 +1,178 @@
+// echcache.go - ECH配置持久化缓存与后台刷新
+package proxyclient
+
+import (
+	"encoding/json"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	echMinTTL            = 60 * time.Second // TTL 下限，避免过于频繁的刷新
+	echRefreshRatio      = 0.8              // 在 TTL 的 80% 处提前刷新
+	echRefreshBackoffMin = 15 * time.Second
+	echRefreshBackoffMax = 30 * time.Minute
+)
+
+// echCacheFile ECH缓存文件内容
+type echCacheFile struct {
+	Domain        string    `json:"domain"`
+	ECHConfigList []byte    `json:"ech_config_list"`
+	TTL           uint32    `json:"ttl"`
+	FetchedAt     time.Time `json:"fetched_at"`
+	Source        string    `json:"source"`
+	Authenticated bool      `json:"authenticated"`
+}
+
+// SetECHCachePath 设置ECH缓存文件路径（需在 Start 之前调用）
+func (c *ProxyClient) SetECHCachePath(path string) {
+	c.echListMu.Lock()
+	defer c.echListMu.Unlock()
+	c.echCachePath = path
+}
+
+func (c *ProxyClient) getECHCachePath() string {
+	c.echListMu.RLock()
+	defer c.echListMu.RUnlock()
+	return c.echCachePath
+}
+
+// loadECHCache 从缓存文件加载ECH配置，即使已过期也先使用，由后台刷新更新
+func (c *ProxyClient) loadECHCache() bool {
+	path := c.getECHCachePath()
+	if path == "" {
+		return false
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			c.logError("读取 ECH 缓存失败: %v", err)
+		}
+		return false
+	}
+
+	var cache echCacheFile
+	if err := json.Unmarshal(data, &cache); err != nil {
+		c.logError("解析 ECH 缓存失败: %v", err)
+		return false
+	}
+	if cache.Domain != c.echDomain || len(cache.ECHConfigList) == 0 {
+		return false
+	}
+	// 损坏的缓存会让每次握手都失败，直到后台刷新成功，校验失败时忽略缓存
+	if err := ValidateECHConfigList(cache.ECHConfigList); err != nil {
+		c.logError("忽略无效的 ECH 缓存: %v", err)
+		return false
+	}
+
+	c.setECH(echLookup{
+		ECH:           cache.ECHConfigList,
+		TTL:           cache.TTL,
+		Authenticated: cache.Authenticated,
+		Server:        cache.Source,
+	}, cache.FetchedAt)
+
+	remaining := time.Until(cache.FetchedAt.Add(time.Duration(cache.TTL) * time.Second))
+	if remaining > 0 {
+		c.logInfo("使用缓存的 ECH 配置，长度: %d 字节，剩余有效期: %v", len(cache.ECHConfigList), remaining.Round(time.Second))
+	} else {
+		c.logInfo("使用已过期的 ECH 缓存，长度: %d 字节，将在后台刷新", len(cache.ECHConfigList))
+	}
+	return true
+}
+
+// saveECHCache 将ECH配置写入缓存文件
+func (c *ProxyClient) saveECHCache(result echLookup, fetchedAt time.Time) {
+	path := c.getECHCachePath()
+	if path == "" {
+		return
+	}
+
+	data, err := json.Marshal(echCacheFile{
+		Domain:        c.echDomain,
+		ECHConfigList: result.ECH,
+		TTL:           result.TTL,
+		FetchedAt:     fetchedAt,
+		Source:        result.Server,
+		Authenticated: result.Authenticated,
+	})
+	if err != nil {
+		c.logError("序列化 ECH 缓存失败: %v", err)
+		return
+	}
+
+	// 先写临时文件再重命名，避免进程被杀时留下半个文件
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".ech-cache-*")
+	if err != nil {
+		c.logError("写入 ECH 缓存失败: %v", err)
+		return
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		c.logError("写入 ECH 缓存失败: %v", err)
+	}
+}
+
+// echRefreshLoop 在ECH配置过期前于后台刷新，失败时指数退避
+func (c *ProxyClient) echRefreshLoop(stop <-chan struct{}) {
+	var backoff time.Duration
+	for {
+		var wait time.Duration
+		if backoff > 0 {
+			wait = withJitter(backoff)
+		} else {
+			wait = c.nextECHRefresh()
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		c.logInfo("后台刷新 ECH 配置...")
+		if err := c.prepareECH(); err != nil {
+			if backoff == 0 {
+				backoff = echRefreshBackoffMin
+			} else if backoff *= 2; backoff > echRefreshBackoffMax {
+				backoff = echRefreshBackoffMax
+			}
+			c.logError("后台刷新 ECH 配置失败，%v 后重试: %v", backoff, err)
+			continue
+		}
+		backoff = 0
+	}
+}
+
+// nextECHRefresh 计算距离下一次计划刷新的时间
+func (c *ProxyClient) nextECHRefresh() time.Duration {
+	c.echListMu.RLock()
+	expiry := c.echExpiry
+	c.echListMu.RUnlock()
+
+	remaining := time.Until(expiry)
+	if remaining <= 0 {
+		return 0
+	}
+	return withJitter(time.Duration(float64(remaining) * echRefreshRatio))
+}
+
+// withJitter 在 d 的基础上随机减少至多 10%，避免多个客户端同时刷新
+func withJitter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	return d - time.Duration(rand.Int63n(int64(d)/10+1))
+}
@@ -0,0 +1,48 @@
+package proxyclient
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadECHCacheRejectsInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ech.json")
+	data, err := json.Marshal(echCacheFile{
+		Domain:        "cloudflare-ech.com",
+		ECHConfigList: []byte{0x00, 0x10, 0xfe, 0x0d, 0x00},
+		TTL:           300,
+		FetchedAt:     time.Now(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	c := &ProxyClient{echDomain: "cloudflare-ech.com", echCachePath: path}
+	c.SetLogCallback(func(level, message string) { t.Logf("[%s] %s", level, message) })
+	if c.loadECHCache() {
+		t.Error("损坏的 ECH 缓存应被忽略")
+	}
+	if _, err := c.getECHList(); err == nil {
+		t.Error("损坏的 ECH 缓存不应被使用")
+	}
+}
+
+func TestStaticECHIgnoresRetryConfigs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ech.json")
+	static := []byte("static")
+	c := &ProxyClient{staticECH: true, echList: static, echCachePath: path}
+	c.applyECHRetryConfigs([]byte("retry"))
+
+	if got, _ := c.getECHList(); string(got) != string(static) {
+		t.Errorf("静态 ECH 配置被替换为 %q", got)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("静态 ECH 配置时不应写入缓存")
+	}
+}
@@ -0,0 +1,252 @@
+// echconfig.go - ECHConfigList 解析与校验（静态配置/离线模式）
+package proxyclient
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+)
+
+const (
+	echConfigVersion = 0xfe0d // RFC 9849 ECHConfig 版本
+
+	hpkeKEMX25519            = 0x0020
+	hpkeKDFHKDFSHA256        = 0x0001
+	hpkeAEADAES128GCM        = 0x0001
+	hpkeAEADAES256GCM        = 0x0002
+	hpkeAEADChaCha20Poly1305 = 0x0003
+	hpkeX25519KeyLength      = 32
+)
+
+// echSourceStatic ECH 配置来自 Config 中的静态配置
+const echSourceStatic = "static"
+
+// ECHCipherSuite HPKE 对称密码套件
+type ECHCipherSuite struct {
+	KDFID  uint16
+	AEADID uint16
+}
+
+// ECHConfigInfo 单个 ECHConfig 的解析结果
+type ECHConfigInfo struct {
+	Version       uint16
+	ConfigID      uint8
+	KEMID         uint16
+	PublicKey     []byte
+	CipherSuites  []ECHCipherSuite
+	MaxNameLength uint8
+	PublicName    string
+	Supported     bool // 本客户端能否使用此配置
+}
+
+// ParseECHConfigList 解析 ECHConfigList，未知版本的配置按规范跳过
+func ParseECHConfigList(data []byte) ([]ECHConfigInfo, error) {
+	if len(data) < 2 {
+		return nil, errors.New("ECHConfigList 过短")
+	}
+	if int(binary.BigEndian.Uint16(data[:2])) != len(data)-2 {
+		return nil, errors.New("ECHConfigList 长度字段与实际长度不符")
+	}
+
+	var configs []ECHConfigInfo
+	rest := data[2:]
+	for len(rest) > 0 {
+		if len(rest) < 4 {
+			return nil, errors.New("ECHConfig 头部不完整")
+		}
+		version := binary.BigEndian.Uint16(rest[:2])
+		length := int(binary.BigEndian.Uint16(rest[2:4]))
+		if len(rest) < 4+length {
+			return nil, fmt.Errorf("ECHConfig 长度越界: %d", length)
+		}
+		contents := rest[4 : 4+length]
+		rest = rest[4+length:]
+
+		if version != echConfigVersion {
+			continue
+		}
+		info, err := parseECHConfigContents(contents)
+		if err != nil {
+			return nil, err
+		}
+		info.Version = version
+		configs = append(configs, info)
+	}
+	return configs, nil
+}
+
+func parseECHConfigContents(data []byte) (ECHConfigInfo, error) {
+	var info ECHConfigInfo
+	r := echReader{data: data}
+
+	info.ConfigID = r.uint8("config_id")
+	info.KEMID = r.uint16("kem_id")
+	info.PublicKey = r.bytes16("public_key")
+	suites := r.bytes16("cipher_suites")
+	if r.err == nil && (len(suites) == 0 || len(suites)%4 != 0) {
+		r.err = errors.New("ECHConfig 字段 cipher_suites 长度无效")
+	}
+	for i := 0; r.err == nil && i < len(suites); i += 4 {
+		info.CipherSuites = append(info.CipherSuites, ECHCipherSuite{
+			KDFID:  binary.BigEndian.Uint16(suites[i : i+2]),
+			AEADID: binary.BigEndian.Uint16(suites[i+2 : i+4]),
+		})
+	}
+	info.MaxNameLength = r.uint8("maximum_name_length")
+	info.PublicName = string(r.bytes8("public_name"))
+	extensions := r.bytes16("extensions")
+	if r.err != nil {
+		return ECHConfigInfo{}, r.err
+	}
+	if len(r.data) != 0 {
+		return ECHConfigInfo{}, errors.New("ECHConfig 末尾存在多余数据")
+	}
+
+	mandatoryExt := false
+	for len(extensions) >= 4 {
+		extType := binary.BigEndian.Uint16(extensions[:2])
+		extLen := int(binary.BigEndian.Uint16(extensions[2:4]))
+		if len(extensions) < 4+extLen {
+			return ECHConfigInfo{}, errors.New("ECHConfig 扩展长度越界")
+		}
+		// 最高位为 1 的扩展是强制扩展，客户端不认识时必须跳过该配置
+		if extType&0x8000 != 0 {
+			mandatoryExt = true
+		}
+		extensions = extensions[4+extLen:]
+	}
+	if len(extensions) != 0 {
+		return ECHConfigInfo{}, errors.New("ECHConfig 扩展格式错误")
+	}
+
+	info.Supported = !mandatoryExt && validECHPublicName(info.PublicName) &&
+		info.KEMID == hpkeKEMX25519 && len(info.PublicKey) == hpkeX25519KeyLength &&
+		hasSupportedECHSuite(info.CipherSuites)
+	return info, nil
+}
+
+func hasSupportedECHSuite(suites []ECHCipherSuite) bool {
+	for _, suite := range suites {
+		if suite.KDFID != hpkeKDFHKDFSHA256 {
+			continue
+		}
+		switch suite.AEADID {
+		case hpkeAEADAES128GCM, hpkeAEADAES256GCM, hpkeAEADChaCha20Poly1305:
+			return true
+		}
+	}
+	return false
+}
+
+// validECHPublicName public name 必须是合法的 DNS 域名而非 IP
+func validECHPublicName(name string) bool {
+	if name == "" || len(name) > 253 || net.ParseIP(name) != nil {
+		return false
+	}
+	for _, label := range strings.Split(name, ".") {
+		if label == "" || len(label) > 63 {
+			return false
+		}
+	}
+	return true
+}
+
+// ValidateECHConfigList 校验 ECHConfigList 中至少有一个本客户端可用的配置
+func ValidateECHConfigList(data []byte) error {
+	configs, err := ParseECHConfigList(data)
+	if err != nil {
+		return err
+	}
+	if len(configs) == 0 {
+		return fmt.Errorf("ECHConfigList 中没有版本为 0x%04x 的配置", echConfigVersion)
+	}
+	for _, config := range configs {
+		if config.Supported {
+			return nil
+		}
+	}
+	first := configs[0]
+	return fmt.Errorf("ECHConfigList 中没有可用的配置 (public_name=%q, kem=0x%04x, 公钥长度=%d, 密码套件=%v)",
+		first.PublicName, first.KEMID, len(first.PublicKey), first.CipherSuites)
+}
+
+// DecodeECHConfigList 解码 base64 文本、PEM (ECHCONFIG) 或原始二进制格式的 ECHConfigList 并校验
+func DecodeECHConfigList(data []byte) ([]byte, error) {
+	raw := data
+	if block, _ := pem.Decode(data); block != nil {
+		raw = block.Bytes
+	} else if text := strings.TrimSpace(string(data)); text != "" {
+		if decoded, err := base64.StdEncoding.DecodeString(text); err == nil {
+			raw = decoded
+		} else if decoded, err := base64.RawStdEncoding.DecodeString(text); err == nil {
+			raw = decoded
+		}
+	}
+	if err := ValidateECHConfigList(raw); err != nil {
+		return nil, fmt.Errorf("无效的 ECH 配置: %w", err)
+	}
+	return raw, nil
+}
+
+// loadStaticECH 从 Config 读取静态 ECH 配置，未配置时返回 nil
+func loadStaticECH(config Config) ([]byte, error) {
+	switch {
+	case config.ECHConfigList != "" && config.ECHConfigFile != "":
+		return nil, errors.New("ECHConfigList 与 ECHConfigFile 只能指定一个")
+	case config.ECHConfigList != "":
+		return DecodeECHConfigList([]byte(config.ECHConfigList))
+	case config.ECHConfigFile != "":
+		data, err := os.ReadFile(config.ECHConfigFile)
+		if err != nil {
+			return nil, fmt.Errorf("读取 ECH 配置文件失败: %w", err)
+		}
+		return DecodeECHConfigList(data)
+	}
+	return nil, nil
+}
+
+// echReader 顺序读取 ECHConfig 字段，出错后后续读取均返回零值
+type echReader struct {
+	data []byte
+	err  error
+}
+
+func (r *echReader) take(n int, field string) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if len(r.data) < n {
+		r.err = fmt.Errorf("ECHConfig 字段 %s 不完整", field)
+		return nil
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b
+}
+
+func (r *echReader) uint8(field string) uint8 {
+	if b := r.take(1, field); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *echReader) uint16(field string) uint16 {
+	if b := r.take(2, field); b != nil {
+		return binary.BigEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (r *echReader) bytes8(field string) []byte {
+	return r.take(int(r.uint8(field)), field)
+}
+
+func (r *echReader) bytes16(field string) []byte {
+	return r.take(int(r.uint16(field)), field)
+}
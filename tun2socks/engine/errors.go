@@ -0,0 +1,191 @@
+// errors.go - 隧道建立失败的错误分类，以及对应的 SOCKS5 应答码与 HTTP 状态码
+package proxyclient
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"syscall"
+)
+
+// 错误码 (供 Android 调用方使用，同时作为 v1 协议 ERROR 帧的错误码)
+const (
+	ErrorCodeNone              = 0 // 无错误
+	ErrorCodeUnknown           = 1 // 其它错误
+	ErrorCodeAuthFailed        = 2 // 服务端认证失败 (令牌错误)
+	ErrorCodeECHRejected       = 3 // 服务端拒绝 ECH
+	ErrorCodeDNSFailed         = 4 // DNS 查询失败 (ECH 配置、服务端或目标域名)
+	ErrorCodeServerUnreachable = 5 // 无法连接服务端
+	ErrorCodeTargetRefused     = 6 // 目标拒绝连接
+	ErrorCodeTargetTimeout     = 7 // 连接目标超时
+	ErrorCodeTargetUnreachable = 8 // 目标不可达
+	ErrorCodeBlocked           = 9 // 被规则拦截
+)
+
+// TunnelError 带错误码的隧道建立错误
+type TunnelError struct {
+	Code int
+	Err  error
+}
+
+func (e *TunnelError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *TunnelError) Unwrap() error {
+	return e.Err
+}
+
+func newTunnelError(code int, err error) *TunnelError {
+	return &TunnelError{Code: code, Err: err}
+}
+
+// ErrorCodeOf 返回错误对应的错误码，err 为 nil 时返回 ErrorCodeNone
+func ErrorCodeOf(err error) int {
+	if err == nil {
+		return ErrorCodeNone
+	}
+	var tunnelErr *TunnelError
+	if errors.As(err, &tunnelErr) {
+		return tunnelErr.Code
+	}
+	return ErrorCodeUnknown
+}
+
+// ErrorCodeDescription 返回错误码的中文说明
+func ErrorCodeDescription(code int) string {
+	switch code {
+	case ErrorCodeNone:
+		return "无错误"
+	case ErrorCodeAuthFailed:
+		return "服务端认证失败"
+	case ErrorCodeECHRejected:
+		return "服务端拒绝 ECH"
+	case ErrorCodeDNSFailed:
+		return "DNS 查询失败"
+	case ErrorCodeServerUnreachable:
+		return "无法连接服务端"
+	case ErrorCodeTargetRefused:
+		return "目标拒绝连接"
+	case ErrorCodeTargetTimeout:
+		return "连接目标超时"
+	case ErrorCodeTargetUnreachable:
+		return "目标不可达"
+	case ErrorCodeBlocked:
+		return "被规则拦截"
+	}
+	return "未知错误"
+}
+
+// ClassifyTargetError 根据连接目标时的错误判断错误码 (供服务端填写 ERROR 帧)
+func ClassifyTargetError(err error) int {
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return ErrorCodeTargetRefused
+	case errors.As(err, &dnsErr):
+		return ErrorCodeDNSFailed
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return ErrorCodeTargetTimeout
+	}
+	return ErrorCodeTargetUnreachable
+}
+
+// classifyTargetMessage 根据服务端 ERROR 消息文本判断错误码 (v0 协议不携带错误码)
+func classifyTargetMessage(message string) int {
+	lower := strings.ToLower(message)
+	switch {
+	case strings.Contains(lower, "refused"):
+		return ErrorCodeTargetRefused
+	case strings.Contains(lower, "timeout"), strings.Contains(lower, "timed out"):
+		return ErrorCodeTargetTimeout
+	case strings.Contains(lower, "no such host"), strings.Contains(lower, "dns"):
+		return ErrorCodeDNSFailed
+	case strings.Contains(lower, "blocked"), strings.Contains(lower, "not allowed"):
+		return ErrorCodeBlocked
+	}
+	return ErrorCodeTargetUnreachable
+}
+
+// classifyDialError 判断连接服务端失败的错误码
+func classifyDialError(err error) *TunnelError {
+	var tunnelErr *TunnelError
+	if errors.As(err, &tunnelErr) {
+		return tunnelErr
+	}
+	if _, rejected := echRejection(err); rejected {
+		return newTunnelError(ErrorCodeECHRejected, err)
+	}
+	var hsErr *handshakeError
+	if errors.As(err, &hsErr) && hsErr.authRejected() {
+		return newTunnelError(ErrorCodeAuthFailed, err)
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return newTunnelError(ErrorCodeDNSFailed, err)
+	}
+	return newTunnelError(ErrorCodeServerUnreachable, err)
+}
+
+// targetError 将服务端返回的 ERROR 消息转换为 TunnelError
+func targetError(msg TunnelMessage) *TunnelError {
+	code := int(msg.ErrorCode)
+	if code == ErrorCodeNone {
+		code = classifyTargetMessage(msg.Error)
+	}
+	return newTunnelError(code, errors.New("ERROR:"+msg.Error))
+}
+
+// socksReplyCode 错误码对应的 SOCKS5 应答码 (RFC 1928)
+func socksReplyCode(code int) byte {
+	switch code {
+	case ErrorCodeAuthFailed, ErrorCodeBlocked:
+		return 0x02 // connection not allowed by ruleset
+	case ErrorCodeECHRejected, ErrorCodeServerUnreachable:
+		return 0x03 // network unreachable
+	case ErrorCodeTargetRefused:
+		return 0x05 // connection refused
+	case ErrorCodeTargetTimeout:
+		return 0x06 // TTL expired
+	}
+	return 0x04 // host unreachable
+}
+
+// httpErrorStatus 错误码对应的 HTTP 状态行
+func httpErrorStatus(code int) string {
+	switch code {
+	case ErrorCodeAuthFailed, ErrorCodeBlocked:
+		return "403 Forbidden"
+	case ErrorCodeTargetTimeout:
+		return "504 Gateway Timeout"
+	}
+	return "502 Bad Gateway"
+}
+
+// httpErrorResponse 构造带说明正文的 HTTP 错误响应。正文只含错误码与说明，
+// 错误详情 (服务端地址、内部错误信息) 只写入日志，不返回给本地客户端
+func httpErrorResponse(err error) []byte {
+	code := ErrorCodeOf(err)
+	body := fmt.Sprintf("代理错误 %d: %s\n", code, ErrorCodeDescription(code))
+	return []byte(fmt.Sprintf("HTTP/1.1 %s\r\nContent-Type: text/plain; charset=utf-8\r\nContent-Length: %d\r\nConnection: close\r\n\r\n%s",
+		httpErrorStatus(code), len(body), body))
+}
+
+// recordError 记录最近一次隧道建立失败
+func (c *ProxyClient) recordError(err error) {
+	if err == nil {
+		return
+	}
+	tunnelErr, ok := err.(*TunnelError)
+	if !ok {
+		tunnelErr = newTunnelError(ErrorCodeOf(err), err)
+	}
+	c.lastError.Store(tunnelErr)
+}
+
+// LastError 返回最近一次隧道建立失败的错误，没有时返回 nil
+func (c *ProxyClient) LastError() *TunnelError {
+	return c.lastError.Load()
+}
@@ -0,0 +1,162 @@
+// fingerprint.go - 模拟浏览器的 TLS ClientHello 指纹 (uTLS)
+package proxyclient
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"math/rand"
+	"net"
+
+	utls "github.com/refraction-networking/utls"
+)
+
+// TLS ClientHello 指纹
+const (
+	FingerprintGo      = "go" // Go crypto/tls 默认指纹
+	FingerprintChrome  = "chrome"
+	FingerprintFirefox = "firefox"
+	FingerprintSafari  = "safari"
+	FingerprintRandom  = "random" // 每个连接随机选择一种浏览器指纹
+)
+
+var browserFingerprints = []string{FingerprintChrome, FingerprintFirefox, FingerprintSafari}
+
+// browserProfile 浏览器指纹对应的 uTLS 预设
+type browserProfile struct {
+	id utls.ClientHelloID
+	// resumes 浏览器复用 TLS 1.3 会话时在 ClientHello 末尾发送 pre_shared_key 扩展。
+	// 预设只记录了首次连接的 ClientHello，为 false 时不添加 PSK 扩展，该指纹不复用会话
+	resumes bool
+}
+
+var browserProfiles = map[string]browserProfile{
+	FingerprintChrome:  {utls.HelloChrome_Auto, true},
+	FingerprintFirefox: {utls.HelloFirefox_Auto, true},
+	// Safari 复用会话时的扩展形态没有可对照的记录，宁可完整握手也不发送可能暴露身份的 PSK 扩展
+	FingerprintSafari: {utls.HelloSafari_Auto, false},
+}
+
+// parseFingerprint 校验指纹名称，空字符串表示默认的 go
+func parseFingerprint(fingerprint string) (string, error) {
+	switch fingerprint {
+	case "":
+		return FingerprintGo, nil
+	case FingerprintGo, FingerprintChrome, FingerprintFirefox, FingerprintSafari, FingerprintRandom:
+		return fingerprint, nil
+	}
+	return "", fmt.Errorf("未知的TLS指纹: %s", fingerprint)
+}
+
+// clientHelloSpec 生成指纹对应的 ClientHelloSpec。
+// spec 中的扩展带有状态，每个连接必须单独生成。
+func clientHelloSpec(fingerprint string, alpn []string) (*utls.ClientHelloSpec, error) {
+	if fingerprint == FingerprintRandom {
+		fingerprint = browserFingerprints[rand.Intn(len(browserFingerprints))]
+	}
+
+	profile, ok := browserProfiles[fingerprint]
+	if !ok {
+		return nil, fmt.Errorf("未知的TLS指纹: %s", fingerprint)
+	}
+
+	spec, err := utls.UTLSIdToSpec(profile.id)
+	if err != nil {
+		return nil, fmt.Errorf("生成 %s 指纹失败: %w", fingerprint, err)
+	}
+
+	hasECH, hasPSK := false, false
+	paddingIdx := -1
+	for i, ext := range spec.Extensions {
+		switch e := ext.(type) {
+		case *utls.ALPNExtension:
+			// 浏览器发起 WebSocket 连接时同样只协商 http/1.1
+			e.AlpnProtocols = alpn
+		case utls.EncryptedClientHelloExtension:
+			hasECH = true
+		case *utls.UtlsPaddingExtension:
+			paddingIdx = i
+		case utls.PreSharedKeyExtension:
+			hasPSK = true
+		}
+	}
+
+	// Safari 的指纹没有 ECH 扩展，需要补上才能承载 ECH；
+	// 未配置 ECHConfigList 时该扩展以 GREASE 形式发送
+	if !hasECH {
+		ech := utls.BoringGREASEECH()
+		if paddingIdx >= 0 {
+			spec.Extensions = append(spec.Extensions[:paddingIdx], append([]utls.TLSExtension{ech}, spec.Extensions[paddingIdx:]...)...)
+		} else {
+			spec.Extensions = append(spec.Extensions, ech)
+		}
+	}
+
+	// 会话复用需要 PSK 扩展 (必须位于最后)，只添加到复用会话时会发送它的浏览器指纹，
+	// 没有可用会话时由 OmitEmptyPsk 省略，首次连接的 ClientHello 与浏览器一致
+	if profile.resumes && !hasPSK {
+		spec.Extensions = append(spec.Extensions, &utls.UtlsPreSharedKeyExtension{})
+	}
+
+	return &spec, nil
+}
+
+// utlsConfig 将 crypto/tls 配置转换为 uTLS 配置
+func utlsConfig(cfg *tls.Config) *utls.Config {
+	uCfg := &utls.Config{
+		ServerName:                     cfg.ServerName,
+		RootCAs:                        cfg.RootCAs,
+		MinVersion:                     cfg.MinVersion,
+		MaxVersion:                     cfg.MaxVersion,
+		NextProtos:                     cfg.NextProtos,
+		InsecureSkipVerify:             cfg.InsecureSkipVerify,
+		VerifyPeerCertificate:          cfg.VerifyPeerCertificate,
+		EncryptedClientHelloConfigList: cfg.EncryptedClientHelloConfigList,
+		OmitEmptyPsk:                   true,
+	}
+	if verify := cfg.VerifyConnection; verify != nil {
+		uCfg.VerifyConnection = func(cs utls.ConnectionState) error {
+			return verify(tls.ConnectionState{
+				Version:           cs.Version,
+				HandshakeComplete: cs.HandshakeComplete,
+				DidResume:         cs.DidResume,
+				ServerName:        cs.ServerName,
+				PeerCertificates:  cs.PeerCertificates,
+				VerifiedChains:    cs.VerifiedChains,
+				ECHAccepted:       cs.ECHAccepted,
+			})
+		}
+	}
+	return uCfg
+}
+
+// dialServerUTLS 返回使用浏览器指纹完成 TLS 握手的拨号函数
+func (c *ProxyClient) dialServerUTLS(tlsCfg *tls.Config, alpn []string) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		spec, err := clientHelloSpec(c.fingerprint, alpn)
+		if err != nil {
+			return nil, err
+		}
+
+		rawConn, err := c.dialServer(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+
+		uCfg := utlsConfig(tlsCfg)
+		if c.sessions != nil {
+			uCfg.ClientSessionCache = utlsSessionCache{c.sessions}
+			uCfg.PreferSkipResumptionOnNilExtension = true
+		}
+		uConn := utls.UClient(rawConn, uCfg, utls.HelloCustom)
+		if err := uConn.ApplyPreset(spec); err != nil {
+			rawConn.Close()
+			return nil, fmt.Errorf("应用 %s 指纹失败: %w", c.fingerprint, err)
+		}
+		if err := uConn.HandshakeContext(ctx); err != nil {
+			rawConn.Close()
+			return nil, err
+		}
+		return uConn, nil
+	}
+}
@@ -0,0 +1,302 @@
+package proxyclient
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+
+	utls "github.com/refraction-networking/utls"
+)
+
+const (
+	extServerName        = 0x0000
+	extSignatureAlgs     = 0x000d
+	extALPN              = 0x0010
+	extSupportedVersions = 0x002b
+	extECH               = 0xfe0d
+	greaseValue          = 0x0a0a // GREASE 值统一替换为此值后再比较
+)
+
+// clientHello 从 ClientHello 原始字节中解析出的字段
+type clientHello struct {
+	cipherSuites        []uint16
+	extensions          []uint16
+	serverName          string
+	alpn                []string
+	signatureAlgorithms []uint16
+	supportedVersions   []uint16
+}
+
+// normalizeGREASE 把随机的 GREASE 值替换为 greaseValue
+func normalizeGREASE(v uint16) uint16 {
+	if v&0x0f0f == 0x0a0a && v>>8 == v&0xff {
+		return greaseValue
+	}
+	return v
+}
+
+// parseClientHello 解析握手消息 (type(1) length(3) body)
+func parseClientHello(t *testing.T, raw []byte) clientHello {
+	t.Helper()
+	fail := func() clientHello {
+		t.Fatalf("ClientHello 格式错误 (%d 字节)", len(raw))
+		return clientHello{}
+	}
+	if len(raw) < 4 || raw[0] != 0x01 {
+		return fail()
+	}
+	// version(2) random(32)
+	data := raw[4:]
+	if len(data) < 34 {
+		return fail()
+	}
+	data = data[34:]
+
+	var ok bool
+	if data, ok = skipVector(data, 1); !ok {
+		return fail()
+	}
+	if len(data) < 2 {
+		return fail()
+	}
+	suitesLen := int(binary.BigEndian.Uint16(data))
+	if len(data) < 2+suitesLen {
+		return fail()
+	}
+	var hello clientHello
+	for i := 2; i < 2+suitesLen; i += 2 {
+		hello.cipherSuites = append(hello.cipherSuites, normalizeGREASE(binary.BigEndian.Uint16(data[i:])))
+	}
+	data = data[2+suitesLen:]
+	if data, ok = skipVector(data, 1); !ok || len(data) < 2 {
+		return fail()
+	}
+
+	extensions := data[2:]
+	for len(extensions) >= 4 {
+		extType := binary.BigEndian.Uint16(extensions)
+		extLen := int(binary.BigEndian.Uint16(extensions[2:]))
+		if len(extensions) < 4+extLen {
+			return fail()
+		}
+		body := extensions[4 : 4+extLen]
+		switch {
+		case extType == extServerName && extLen > 5:
+			hello.serverName = string(body[5:])
+		case extType == extALPN && extLen > 2:
+			for list := body[2:]; len(list) > 0 && len(list) > int(list[0]); list = list[1+int(list[0]):] {
+				hello.alpn = append(hello.alpn, string(list[1:1+int(list[0])]))
+			}
+		case extType == extSignatureAlgs && extLen > 2:
+			for i := 2; i+1 < extLen; i += 2 {
+				hello.signatureAlgorithms = append(hello.signatureAlgorithms, binary.BigEndian.Uint16(body[i:]))
+			}
+		case extType == extSupportedVersions && extLen > 1:
+			for i := 1; i+1 < extLen; i += 2 {
+				hello.supportedVersions = append(hello.supportedVersions, normalizeGREASE(binary.BigEndian.Uint16(body[i:])))
+			}
+		}
+		hello.extensions = append(hello.extensions, normalizeGREASE(extType))
+		extensions = extensions[4+extLen:]
+	}
+	return hello
+}
+
+// buildClientHello 生成 ClientHello 但不发送
+func buildClientHello(t *testing.T, cfg *utls.Config, id utls.ClientHelloID, spec *utls.ClientHelloSpec) clientHello {
+	t.Helper()
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	uConn := utls.UClient(client, cfg, id)
+	if spec != nil {
+		if err := uConn.ApplyPreset(spec); err != nil {
+			t.Fatalf("ApplyPreset: %v", err)
+		}
+	}
+	if err := uConn.BuildHandshakeState(); err != nil {
+		t.Fatalf("BuildHandshakeState: %v", err)
+	}
+	return parseClientHello(t, uConn.HandshakeState.Hello.Raw)
+}
+
+// testECHConfigList 生成只含一个 X25519 配置的 ECHConfigList
+func testECHConfigList(t *testing.T, publicName string) []byte {
+	t.Helper()
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKey := key.PublicKey().Bytes()
+
+	contents := []byte{1}
+	contents = binary.BigEndian.AppendUint16(contents, 0x0020) // DHKEM(X25519)
+	contents = binary.BigEndian.AppendUint16(contents, uint16(len(publicKey)))
+	contents = append(contents, publicKey...)
+	contents = binary.BigEndian.AppendUint16(contents, 4)
+	contents = binary.BigEndian.AppendUint16(contents, 0x0001) // HKDF-SHA256
+	contents = binary.BigEndian.AppendUint16(contents, 0x0001) // AES-128-GCM
+	contents = append(contents, 0, byte(len(publicName)))
+	contents = append(contents, publicName...)
+	contents = binary.BigEndian.AppendUint16(contents, 0)
+
+	config := binary.BigEndian.AppendUint16(nil, extECH)
+	config = binary.BigEndian.AppendUint16(config, uint16(len(contents)))
+	config = append(config, contents...)
+	list := binary.BigEndian.AppendUint16(nil, uint16(len(config)))
+	return append(list, config...)
+}
+
+// ja4 计算 ClientHello 的 JA4 指纹 (TCP)，GREASE 值已由 parseClientHello 统一替换
+func ja4(hello clientHello) string {
+	hexList := func(values []uint16) []string {
+		var list []string
+		for _, v := range values {
+			if v != greaseValue {
+				list = append(list, fmt.Sprintf("%04x", v))
+			}
+		}
+		return list
+	}
+	hash := func(s string) string {
+		sum := sha256.Sum256([]byte(s))
+		return hex.EncodeToString(sum[:])[:12]
+	}
+
+	version := "00"
+	if slices.Contains(hello.supportedVersions, 0x0304) {
+		version = "13"
+	}
+	sni := "i"
+	if hello.serverName != "" {
+		sni = "d"
+	}
+	alpn := "00"
+	if len(hello.alpn) > 0 && hello.alpn[0] != "" {
+		first := hello.alpn[0]
+		alpn = first[:1] + first[len(first)-1:]
+	}
+
+	suites := hexList(hello.cipherSuites)
+	extensions := hexList(hello.extensions)
+	// 扩展计数包含 SNI 与 ALPN，哈希时去除
+	hashed := slices.DeleteFunc(slices.Clone(extensions), func(ext string) bool { return ext == "0000" || ext == "0010" })
+	slices.Sort(suites)
+	slices.Sort(hashed)
+
+	return fmt.Sprintf("t%s%s%02d%02d%s_%s_%s", version, sni, len(suites), len(extensions), alpn,
+		hash(strings.Join(suites, ",")),
+		hash(strings.Join(hashed, ",")+"_"+strings.Join(hexList(hello.signatureAlgorithms), ",")))
+}
+
+func TestClientHelloMatchesBrowser(t *testing.T) {
+	// 浏览器首次连接 (h2, http/1.1) 的 JA4 取自公开的抓包记录 (ja4db)，扩展顺序取自同一抓包，
+	// 与 uTLS 版本无关：升级 uTLS 后预设若偏离浏览器，这里会失败
+	profiles := []struct {
+		fingerprint string
+		ja4         string
+		extensions  []uint16 // 扩展顺序，Chrome 每次随机排列，不比较
+	}{
+		{FingerprintChrome, "t13d1516h2_8daaf6152771_d8a2da3f94cd", nil}, // Chrome 133+
+		{FingerprintFirefox, "t13d1715h2_5b57614c22b0_5c2c66f702b0", []uint16{ // Firefox 120+
+			0x0000, 0x0017, 0xff01, 0x000a, 0x000b, 0x0023, 0x0010, 0x0005, 0x0022, 0x0033, 0x002b, 0x000d, 0x002d, 0x001c, 0xfe0d,
+		}},
+		{FingerprintSafari, "t13d2014h2_a09f3c656075_14788d8d241b", []uint16{ // Safari 16
+			0x0a0a, 0x0000, 0x0017, 0xff01, 0x000a, 0x000b, 0x0010, 0x0005, 0x000d, 0x0012, 0x0033, 0x002d, 0x002b, 0x001b, 0x0a0a, 0x0015,
+		}},
+	}
+
+	for _, p := range profiles {
+		t.Run(p.fingerprint, func(t *testing.T) {
+			spec, err := clientHelloSpec(p.fingerprint, []string{"h2", "http/1.1"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			hello := buildClientHello(t, &utls.Config{ServerName: "example.com", OmitEmptyPsk: true}, utls.HelloCustom, spec)
+			got := ja4(hello)
+
+			if p.fingerprint == FingerprintSafari {
+				// Safari 不发送 ECH 扩展，clientHelloSpec 在 padding 之前补上 ECH 扩展，ClientHello
+				// 变长后不再需要 padding。扩展数目不变，密码套件一致，只有扩展哈希 (JA4_c) 不同
+				want := slices.Clone(p.extensions)
+				want[len(want)-1] = extECH
+				if !slices.Equal(hello.extensions, want) {
+					t.Errorf("扩展顺序与 Safari 不一致:\n got  %04x\n want %04x", hello.extensions, want)
+				}
+				if prefix := p.ja4[:strings.LastIndex(p.ja4, "_")]; !strings.HasPrefix(got, prefix+"_") {
+					t.Errorf("JA4 = %s，应以 %s_ 开头", got, prefix)
+				}
+				return
+			}
+
+			if got != p.ja4 {
+				t.Errorf("JA4 = %s，浏览器为 %s", got, p.ja4)
+			}
+			if p.extensions != nil && !slices.Equal(hello.extensions, p.extensions) {
+				t.Errorf("扩展顺序与浏览器不一致:\n got  %04x\n want %04x", hello.extensions, p.extensions)
+			}
+		})
+	}
+}
+
+func TestClientHelloSpecPSK(t *testing.T) {
+	// 只有复用会话时发送 pre_shared_key 的浏览器指纹带 PSK 扩展，且必须是最后一个扩展
+	for _, fingerprint := range browserFingerprints {
+		spec, err := clientHelloSpec(fingerprint, []string{"http/1.1"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		pskIdx := slices.IndexFunc(spec.Extensions, func(ext utls.TLSExtension) bool {
+			_, ok := ext.(utls.PreSharedKeyExtension)
+			return ok
+		})
+		if resumes := browserProfiles[fingerprint].resumes; resumes != (pskIdx >= 0) {
+			t.Errorf("%s: PSK 扩展位置 %d，复用会话: %v", fingerprint, pskIdx, resumes)
+		} else if resumes && pskIdx != len(spec.Extensions)-1 {
+			t.Errorf("%s: PSK 扩展位于 %d，应为最后一个", fingerprint, pskIdx)
+		}
+	}
+}
+
+func TestClientHelloSpecECH(t *testing.T) {
+	configList := testECHConfigList(t, "public.example")
+	for _, fingerprint := range browserFingerprints {
+		t.Run(fingerprint, func(t *testing.T) {
+			spec, err := clientHelloSpec(fingerprint, []string{"http/1.1"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			cfg := &utls.Config{ServerName: "example.com", EncryptedClientHelloConfigList: configList, OmitEmptyPsk: true}
+			hello := buildClientHello(t, cfg, utls.HelloCustom, spec)
+			if !slices.Contains(hello.extensions, extECH) {
+				t.Errorf("缺少 ECH 扩展: %04x", hello.extensions)
+			}
+			// 外层 ClientHello 使用 ECH 配置中的 public name，真实 SNI 只在内层
+			if hello.serverName != "public.example" {
+				t.Errorf("外层 SNI = %q, want public.example", hello.serverName)
+			}
+		})
+	}
+}
+
+func TestParseFingerprint(t *testing.T) {
+	if got, err := parseFingerprint(""); err != nil || got != FingerprintGo {
+		t.Errorf(`parseFingerprint("") = %q, %v`, got, err)
+	}
+	for _, name := range []string{FingerprintGo, FingerprintChrome, FingerprintFirefox, FingerprintSafari, FingerprintRandom} {
+		if got, err := parseFingerprint(name); err != nil || got != name {
+			t.Errorf("parseFingerprint(%q) = %q, %v", name, got, err)
+		}
+	}
+	if _, err := parseFingerprint("edge"); err == nil {
+		t.Error(`parseFingerprint("edge") 应返回错误`)
+	}
+}
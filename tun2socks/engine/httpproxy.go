@@ -0,0 +1,440 @@
+// httpproxy.go - 基于 net/http 的 HTTP 正向代理 (绝对 URI 请求)
+//
+// 每个客户端连接上按顺序处理多个请求 (持久连接)，请求经隧道转发给目标服务器，
+// 到目标的连接由 http.Transport 复用。分块传输、Expect: 100-continue 和 HTTP/1.0
+// 客户端由 net/http 的读写逻辑处理，逐跳头部按 RFC 9110 第 7.6.1 节移除。
+package proxyclient
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	forwardIdleConnTimeout = 90 * time.Second
+	forwardMaxIdlePerHost  = 4
+	expectContinueTimeout  = time.Second
+)
+
+// hopByHopHeaders 只对单跳连接有意义、代理不得转发的头部
+var hopByHopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"TE",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// newForwardTransport 创建为来自 src 的请求经隧道连接目标的 http.Transport
+func (c *ProxyClient) newForwardTransport(src connSource) *http.Transport {
+	return &http.Transport{
+		Proxy: nil,
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return c.dialTarget(ctx, addr, src)
+		},
+		// 保持客户端原始的 Accept-Encoding，不由 Transport 自动解压
+		DisableCompression:    true,
+		MaxIdleConnsPerHost:   forwardMaxIdlePerHost,
+		IdleConnTimeout:       forwardIdleConnTimeout,
+		ExpectContinueTimeout: expectContinueTimeout,
+		// 默认不限制，目标可以像原始隧道一样任意长时间后才响应 (长轮询等)
+		ResponseHeaderTimeout: c.timeouts.response,
+	}
+}
+
+// forwardHTTP 转发一个请求并写回响应，返回连接是否可以继续处理下一个请求
+func (c *ProxyClient) forwardHTTP(conn net.Conn, req *http.Request, clientAddr string, src connSource) bool {
+	defer req.Body.Close()
+
+	target, ok := forwardTarget(req)
+	if !ok {
+		writeSimpleResponse(conn, req, http.StatusBadRequest, "无效的请求地址\n")
+		return false
+	}
+
+	if err := c.checkBlocked(target, src); err != nil {
+		c.logInfo("已拦截: %s -> %s", clientAddr, req.URL)
+		conn.Write(httpErrorResponse(err))
+		return false
+	}
+
+	c.logInfo("HTTP-%s: %s -> %s", req.Method, clientAddr, req.URL)
+
+	clientClose := req.Close
+	body := &continueReader{body: req.Body, conn: conn, expect: expectsContinue(req)}
+	if req.Body != http.NoBody {
+		req.Body = body
+	}
+
+	outReq := req.Clone(req.Context())
+	outReq.RequestURI = ""
+	outReq.URL.Scheme = "http"
+	outReq.URL.Host = target
+	outReq.Close = false
+	removeHopByHopHeaders(outReq.Header)
+	if !body.expect {
+		outReq.Header.Del("Expect")
+	}
+
+	resp, err := c.forwardTransport(src).RoundTrip(outReq)
+	body.respond()
+	if err != nil {
+		c.recordError(err)
+		c.logError("HTTP-%s 代理失败 %s: %v", req.Method, clientAddr, err)
+		conn.Write(httpErrorResponse(err))
+		return false
+	}
+	defer resp.Body.Close()
+
+	removeHopByHopHeaders(resp.Header)
+	resp.ProtoMajor, resp.ProtoMinor = 1, 1
+	resp.Close = clientClose || !body.drained()
+
+	if req.ProtoMajor == 1 && req.ProtoMinor == 0 {
+		// HTTP/1.0 客户端不支持分块编码：长度未知时以关闭连接结束响应
+		if resp.ContentLength < 0 {
+			resp.TransferEncoding = nil
+			resp.Close = true
+		}
+		if !resp.Close {
+			resp.Header.Set("Connection", "keep-alive")
+		}
+	}
+
+	if err := resp.Write(conn); err != nil {
+		return false
+	}
+	return !resp.Close
+}
+
+// isUpgradeRequest 判断是否为协议升级请求 (如 ws:// 的 WebSocket 握手)
+func isUpgradeRequest(req *http.Request) bool {
+	if req.Header.Get("Upgrade") == "" {
+		return false
+	}
+	for _, value := range req.Header.Values("Connection") {
+		for _, token := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// forwardUpgrade 把协议升级请求改写为 origin-form 后作为首包经原始隧道发送，
+// 之后双向转发原始字节，升级响应与升级后的数据都由目标直接返回
+func (c *ProxyClient) forwardUpgrade(conn net.Conn, req *http.Request, clientAddr string, src connSource) {
+	target, ok := forwardTarget(req)
+	if !ok {
+		writeSimpleResponse(conn, req, http.StatusBadRequest, "无效的请求地址\n")
+		return
+	}
+
+	outReq := req.Clone(req.Context())
+	outReq.URL.Scheme = "http"
+	outReq.URL.Host = target
+	outReq.Header.Del("Proxy-Connection")
+	outReq.Header.Del("Proxy-Authorization")
+	if _, ok := outReq.Header["User-Agent"]; !ok {
+		// Request.Write 在缺少 User-Agent 时会补上 Go 的默认值
+		outReq.Header["User-Agent"] = []string{""}
+	}
+	var firstFrame bytes.Buffer
+	if err := outReq.Write(&firstFrame); err != nil {
+		writeSimpleResponse(conn, req, http.StatusBadRequest, "无效的请求\n")
+		return
+	}
+
+	c.logInfo("HTTP-UPGRADE %s: %s -> %s", req.Header.Get("Upgrade"), clientAddr, req.URL)
+	if err := c.handleTunnel(conn, target, clientAddr, src, modeHTTPUpgrade, firstFrame.Bytes()); err != nil {
+		if !isNormalCloseError(err) {
+			c.logError("HTTP-UPGRADE 代理失败 %s: %v", clientAddr, err)
+		}
+	}
+}
+
+// forwardTransport 返回转发来自 src 的请求的 http.Transport。
+// 到目标的连接按来源分别复用，连接上的限速与建立时匹配的规则一致
+func (c *ProxyClient) forwardTransport(src connSource) *http.Transport {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	transport := c.httpTransports[src]
+	if transport == nil {
+		if c.httpTransports == nil {
+			c.httpTransports = make(map[connSource]*http.Transport)
+		}
+		transport = c.newForwardTransport(src)
+		c.httpTransports[src] = transport
+	}
+	return transport
+}
+
+// closeForwardTransport 关闭转发复用的空闲连接
+func (c *ProxyClient) closeForwardTransport() {
+	for _, transport := range c.httpTransports {
+		transport.CloseIdleConnections()
+	}
+	c.httpTransports = nil
+}
+
+// forwardTarget 返回请求的目标 host:port，只支持 http 绝对 URI 或带 Host 头的请求
+func forwardTarget(req *http.Request) (string, bool) {
+	host := req.URL.Host
+	if req.URL.IsAbs() {
+		if req.URL.Scheme != "http" {
+			return "", false
+		}
+	} else {
+		host = req.Host
+	}
+	if host == "" {
+		return "", false
+	}
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(strings.Trim(host, "[]"), "80")
+	}
+	return host, true
+}
+
+// removeHopByHopHeaders 删除逐跳头部以及 Connection 中列出的头部
+func removeHopByHopHeaders(header http.Header) {
+	for _, value := range header.Values("Connection") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				header.Del(name)
+			}
+		}
+	}
+	for _, name := range hopByHopHeaders {
+		header.Del(name)
+	}
+}
+
+func expectsContinue(req *http.Request) bool {
+	return req.ProtoAtLeast(1, 1) && strings.EqualFold(req.Header.Get("Expect"), "100-continue")
+}
+
+// continueReader 在首次读取请求体时向客户端发送 100 Continue，并记录请求体是否已读完。
+// 目标服务器直接返回最终响应时 Transport 不会读取请求体，此时不发送 100 Continue，
+// 且客户端连接不能继续复用。
+//
+// Read 在 Transport 写请求的 goroutine 中调用，可能与写回最终响应同时发生：
+// 写最终响应前调用 respond，等待正在写的 100 Continue 完成，此后不再发送。
+type continueReader struct {
+	body      io.ReadCloser
+	conn      net.Conn
+	expect    bool
+	continued bool
+	responded bool
+	eof       bool
+	mu        sync.Mutex
+}
+
+func (r *continueReader) Read(p []byte) (int, error) {
+	if r.expect {
+		r.sendContinue()
+	}
+	n, err := r.body.Read(p)
+	if err == io.EOF {
+		r.mu.Lock()
+		r.eof = true
+		r.mu.Unlock()
+	}
+	return n, err
+}
+
+func (r *continueReader) sendContinue() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.continued || r.responded {
+		return
+	}
+	r.continued = true
+	r.conn.Write([]byte("HTTP/1.1 100 Continue\r\n\r\n"))
+}
+
+// respond 在向客户端写最终响应前调用
+func (r *continueReader) respond() {
+	r.mu.Lock()
+	r.responded = true
+	r.mu.Unlock()
+}
+
+func (r *continueReader) Close() error {
+	return r.body.Close()
+}
+
+// drained 请求体是否已完整读取 (无请求体时为 true)
+func (r *continueReader) drained() bool {
+	if r.body == http.NoBody {
+		return true
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.eof
+}
+
+// writeSimpleResponse 写回简单的文本响应并关闭连接
+func writeSimpleResponse(conn net.Conn, req *http.Request, status int, body string) {
+	resp := &http.Response{
+		StatusCode:    status,
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Request:       req,
+		Header:        http.Header{"Content-Type": {"text/plain; charset=utf-8"}},
+		ContentLength: int64(len(body)),
+		Body:          io.NopCloser(strings.NewReader(body)),
+		Close:         true,
+	}
+	resp.Write(conn)
+}
+
+func isTimeoutError(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// ======================== 隧道流 ========================
+
+// dialTarget 建立到目标的隧道，返回可直接读写的 net.Conn
+func (c *ProxyClient) dialTarget(ctx context.Context, target string, src connSource) (net.Conn, error) {
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		conn, err := c.openTunnelStream(target, src)
+		done <- result{conn, err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.conn, r.err
+	case <-ctx.Done():
+		go func() {
+			if r := <-done; r.conn != nil {
+				r.conn.Close()
+			}
+		}()
+		return nil, ctx.Err()
+	}
+}
+
+func (c *ProxyClient) openTunnelStream(target string, src connSource) (net.Conn, error) {
+	tunnel, err := c.dialTunnel(c.timeouts.maxRetries)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := connectTarget(tunnel, TunnelMessage{Type: FrameConnect, Target: target}); err != nil {
+		tunnel.Close()
+		return nil, err
+	}
+
+	return c.newTunnelStream(tunnel, target, src), nil
+}
+
+// newTunnelStream 包装已连接目标的隧道，并定期发送 ping
+func (c *ProxyClient) newTunnelStream(tunnel *tunnelConn, target string, src connSource) *tunnelStream {
+	stream := &tunnelStream{tunnel: tunnel, target: target, limiter: c.newConnLimiter(target, src), done: make(chan struct{})}
+	go stream.keepAlive(c.timeouts.ping)
+	return stream
+}
+
+// tunnelStream 把隧道包装为 net.Conn。隧道不支持截止时间，Set*Deadline 不生效。
+type tunnelStream struct {
+	tunnel    *tunnelConn
+	target    string
+	limiter   *connLimiter
+	pending   []byte
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func (s *tunnelStream) Read(p []byte) (int, error) {
+	for len(s.pending) == 0 {
+		msg, err := s.tunnel.receive()
+		if err != nil {
+			return 0, err
+		}
+		switch msg.Type {
+		case FrameData:
+			s.limiter.waitDownload(len(msg.Payload), s.tunnel.done)
+			s.pending = msg.Payload
+		case FrameClose:
+			return 0, io.EOF
+		}
+	}
+	n := copy(p, s.pending)
+	s.pending = s.pending[n:]
+	return n, nil
+}
+
+func (s *tunnelStream) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > 32768 {
+			chunk = chunk[:32768]
+		}
+		s.limiter.waitUpload(len(chunk), s.tunnel.done)
+		if err := s.tunnel.send(TunnelMessage{Type: FrameData, Payload: chunk}); err != nil {
+			return written, err
+		}
+		written += len(chunk)
+		p = p[len(chunk):]
+	}
+	return written, nil
+}
+
+func (s *tunnelStream) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		close(s.done)
+		s.tunnel.send(TunnelMessage{Type: FrameClose})
+		err = s.tunnel.Close()
+	})
+	return err
+}
+
+// keepAlive 定期发送 ping，防止复用的空闲隧道被中间设备断开
+func (s *tunnelStream) keepAlive(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.tunnel.ping(); err != nil {
+				return
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (s *tunnelStream) LocalAddr() net.Addr                { return tunnelAddr("local") }
+func (s *tunnelStream) RemoteAddr() net.Addr               { return tunnelAddr(s.target) }
+func (s *tunnelStream) SetDeadline(t time.Time) error      { return nil }
+func (s *tunnelStream) SetReadDeadline(t time.Time) error  { return nil }
+func (s *tunnelStream) SetWriteDeadline(t time.Time) error { return nil }
+
+// tunnelAddr 隧道两端的地址
+type tunnelAddr string
+
+func (a tunnelAddr) Network() string { return "tunnel" }
+func (a tunnelAddr) String() string  { return string(a) }
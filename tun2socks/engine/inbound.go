@@ -0,0 +1,338 @@
+// inbound.go - 多入站监听
+//
+// 每个入站有独立的监听地址、协议、认证和标签，由 ProxyClient 统一启动和停止。
+// 监听地址为 host:port (含 IPv6 的 [::1]:1080) 或 unix:/path/to/socket。
+package proxyclient
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+	"unicode"
+)
+
+// 入站协议
+const (
+	InboundMixed    = "mixed"  // SOCKS5 与 HTTP，按首字节区分
+	InboundSOCKS5   = "socks5" // 只接受 SOCKS5
+	InboundHTTP     = "http"   // 只接受 HTTP 代理 (含 CONNECT)
+	InboundRedirect = TransparentRedirect
+	InboundTProxy   = TransparentTProxy
+)
+
+const unixPrefix = "unix:"
+
+// Inbound 入站配置
+type Inbound struct {
+	Listen   string // 监听地址: host:port 或 unix:/path/to/socket
+	Protocol string // 协议: mixed(默认) / socks5 / http / redirect / tproxy
+	Username string // 认证用户名 (可选，SOCKS5 用户名密码认证 / HTTP Proxy-Authorization Basic)
+	Password string // 认证密码
+	Tag      string // 入站标签，用于日志区分与规则匹配 (inbound:<标签>，默认: 监听地址)
+}
+
+// inboundListener 运行中的入站
+type inboundListener struct {
+	Inbound
+	listener net.Listener
+	udp      *net.UDPConn // 仅 tproxy
+}
+
+func (in *inboundListener) close() {
+	if in.listener != nil {
+		in.listener.Close()
+	}
+	if in.udp != nil {
+		in.udp.Close()
+	}
+}
+
+// source 从该入站接受的连接的来源
+func (in *inboundListener) source() connSource {
+	return connSource{inbound: in.Tag}
+}
+
+func (in *inboundListener) transparent() bool {
+	return in.Protocol == InboundRedirect || in.Protocol == InboundTProxy
+}
+
+// requiresAuth 是否需要认证
+func (in *inboundListener) requiresAuth() bool {
+	return in.Username != ""
+}
+
+// checkCredentials 以固定时间比较用户名和密码
+func (in *inboundListener) checkCredentials(username, password string) bool {
+	userOK := subtle.ConstantTimeCompare([]byte(username), []byte(in.Username))
+	passOK := subtle.ConstantTimeCompare([]byte(password), []byte(in.Password))
+	return userOK&passOK == 1
+}
+
+// validateInbound 校验入站配置并填充默认值
+func validateInbound(in *Inbound) error {
+	if in.Listen == "" {
+		return errors.New("入站监听地址不能为空")
+	}
+	switch in.Protocol {
+	case "":
+		in.Protocol = InboundMixed
+	case InboundMixed, InboundSOCKS5, InboundHTTP, InboundRedirect, InboundTProxy:
+	default:
+		return fmt.Errorf("未知的入站协议: %s", in.Protocol)
+	}
+
+	isUnix := strings.HasPrefix(in.Listen, unixPrefix)
+	if isUnix {
+		if strings.TrimPrefix(in.Listen, unixPrefix) == "" {
+			return fmt.Errorf("无效的入站监听地址: %s", in.Listen)
+		}
+	} else if _, _, err := net.SplitHostPort(in.Listen); err != nil {
+		return fmt.Errorf("无效的入站监听地址 %s: %w", in.Listen, err)
+	}
+
+	if in.Protocol == InboundRedirect || in.Protocol == InboundTProxy {
+		if isUnix {
+			return fmt.Errorf("透明代理入站不能监听 Unix 套接字: %s", in.Listen)
+		}
+		if in.Username != "" || in.Password != "" {
+			return fmt.Errorf("透明代理入站不支持认证: %s", in.Listen)
+		}
+	}
+	if in.Username == "" && in.Password != "" {
+		return fmt.Errorf("入站 %s 设置了密码但未设置用户名", in.Listen)
+	}
+	if len(in.Username) > 255 || len(in.Password) > 255 {
+		return fmt.Errorf("入站 %s 的用户名或密码过长 (最多 255 字节)", in.Listen)
+	}
+
+	if in.Tag == "" {
+		in.Tag = in.Listen
+	}
+	return nil
+}
+
+// listenInbound 按入站配置创建监听套接字
+func (c *ProxyClient) listenInbound(config Inbound) (*inboundListener, error) {
+	in := &inboundListener{Inbound: config}
+
+	switch {
+	case in.transparent():
+		listener, err := listenTransparentTCP(in.Listen, in.Protocol)
+		if err != nil {
+			return nil, err
+		}
+		in.listener = listener
+		if in.Protocol == InboundTProxy {
+			udp, err := listenTransparentUDP(in.Listen)
+			if err != nil {
+				listener.Close()
+				return nil, fmt.Errorf("UDP 监听失败: %w", err)
+			}
+			in.udp = udp
+		}
+
+	case strings.HasPrefix(in.Listen, unixPrefix):
+		path := strings.TrimPrefix(in.Listen, unixPrefix)
+		if err := removeStaleSocket(path); err != nil {
+			return nil, err
+		}
+		listener, err := net.Listen("unix", path)
+		if err != nil {
+			return nil, err
+		}
+		in.listener = listener
+
+	default:
+		listenConfig := net.ListenConfig{KeepAlive: c.timeouts.keepAlive}
+		listener, err := listenConfig.Listen(context.Background(), "tcp", in.Listen)
+		if err != nil {
+			return nil, err
+		}
+		in.listener = listener
+	}
+	return in, nil
+}
+
+// removeStaleSocket 删除上次异常退出遗留的套接字文件。文件仍有进程在监听时返回错误，
+// 只有连接被拒绝 (无人监听) 才删除，避免抢占另一个实例正在使用的套接字
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if err != nil || info.Mode()&os.ModeSocket == 0 {
+		return nil
+	}
+	conn, err := net.DialTimeout("unix", path, time.Second)
+	if err == nil {
+		conn.Close()
+		return fmt.Errorf("套接字 %s 正在被其它进程使用", path)
+	}
+	if !errors.Is(err, syscall.ECONNREFUSED) {
+		return nil
+	}
+	return os.Remove(path)
+}
+
+// startInbounds 启动所有入站，任一失败时关闭已启动的入站
+func (c *ProxyClient) startInbounds(configs []Inbound) ([]*inboundListener, error) {
+	var listeners []*inboundListener
+	for _, config := range configs {
+		in, err := c.listenInbound(config)
+		if err != nil {
+			for _, started := range listeners {
+				started.close()
+			}
+			return nil, fmt.Errorf("监听失败 %s (%s): %w", config.Listen, config.Protocol, err)
+		}
+		listeners = append(listeners, in)
+	}
+	return listeners, nil
+}
+
+// serveInbound 启动入站的接受循环
+func (c *ProxyClient) serveInbound(in *inboundListener) {
+	go c.acceptLoop(in)
+	if in.udp != nil {
+		go c.serveTransparentUDP(in)
+	}
+
+	auth := ""
+	if in.requiresAuth() {
+		auth = "，需要认证"
+	}
+	c.logInfo("入站 [%s] 启动: %s (%s%s)", in.Tag, in.listener.Addr(), in.Protocol, auth)
+}
+
+// inboundClientAddr 返回日志中使用的客户端地址 (Unix 套接字没有对端地址)
+func inboundClientAddr(conn net.Conn) string {
+	addr := conn.RemoteAddr()
+	if addr == nil || addr.String() == "" || addr.String() == "@" {
+		return "unix:" + conn.LocalAddr().String()
+	}
+	return addr.String()
+}
+
+// ======================== 按来源匹配规则 ========================
+
+// 规则行首的生效范围，如 "inbound:guest ||ads.example.com^"、"app:com.example * 0 1M"
+const (
+	scopeInboundPrefix = "inbound:"
+	scopeAppPrefix     = "app:"
+)
+
+// connSource 连接的来源，用于匹配拦截与限速规则
+type connSource struct {
+	inbound string // 入站标签
+	app     string // 所属应用的包名，逗号分隔 (未知时为空，见 identifyApp)
+}
+
+// ruleScope 规则的生效范围，零值对所有连接生效
+type ruleScope struct {
+	inbound string
+	app     string
+}
+
+func (s ruleScope) matches(src connSource) bool {
+	if s.inbound != "" && s.inbound != src.inbound {
+		return false
+	}
+	if s.app != "" && !slices.Contains(strings.Split(src.app, ","), s.app) {
+		return false
+	}
+	return true
+}
+
+// parseRuleScope 解析行首的 inbound:<标签> 与 app:<包名> (可同时使用)，返回生效范围与规则的其余部分
+func parseRuleScope(line string) (ruleScope, string, error) {
+	var scope ruleScope
+	for {
+		var field *string
+		var prefix string
+		switch {
+		case strings.HasPrefix(line, scopeInboundPrefix):
+			field, prefix = &scope.inbound, scopeInboundPrefix
+		case strings.HasPrefix(line, scopeAppPrefix):
+			field, prefix = &scope.app, scopeAppPrefix
+		default:
+			return scope, line, nil
+		}
+		value, rest := line[len(prefix):], ""
+		if i := strings.IndexFunc(value, unicode.IsSpace); i >= 0 {
+			value, rest = value[:i], value[i:]
+		}
+		if value == "" || *field != "" {
+			return ruleScope{}, "", fmt.Errorf("无效的规则范围: %s", line)
+		}
+		*field = value
+		line = strings.TrimSpace(rest)
+	}
+}
+
+// ======================== 认证 ========================
+
+// socks5UserPassAuth 完成 RFC 1929 用户名密码子协商，返回是否认证成功
+func socks5UserPassAuth(conn net.Conn, in *inboundListener) bool {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil || header[0] != 0x01 {
+		return false
+	}
+	username := make([]byte, header[1])
+	if _, err := io.ReadFull(conn, username); err != nil {
+		return false
+	}
+	length := make([]byte, 1)
+	if _, err := io.ReadFull(conn, length); err != nil {
+		return false
+	}
+	password := make([]byte, length[0])
+	if _, err := io.ReadFull(conn, password); err != nil {
+		return false
+	}
+
+	if !in.checkCredentials(string(username), string(password)) {
+		conn.Write([]byte{0x01, 0x01})
+		return false
+	}
+	_, err := conn.Write([]byte{0x01, 0x00})
+	return err == nil
+}
+
+// checkProxyAuthorization 校验 HTTP 请求的 Proxy-Authorization (Basic)
+func checkProxyAuthorization(req *http.Request, in *inboundListener) bool {
+	if !in.requiresAuth() {
+		return true
+	}
+	scheme, encoded, ok := strings.Cut(req.Header.Get("Proxy-Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Basic") {
+		return false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return false
+	}
+	username, password, ok := strings.Cut(string(decoded), ":")
+	return ok && in.checkCredentials(username, password)
+}
+
+// writeProxyAuthRequired 返回 407 并关闭连接
+func writeProxyAuthRequired(conn net.Conn, req *http.Request) {
+	resp := &http.Response{
+		StatusCode:    http.StatusProxyAuthRequired,
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Request:       req,
+		Header:        http.Header{"Proxy-Authenticate": {`Basic realm="proxy"`}, "Content-Type": {"text/plain; charset=utf-8"}},
+		ContentLength: int64(len("需要代理认证\n")),
+		Body:          io.NopCloser(strings.NewReader("需要代理认证\n")),
+		Close:         true,
+	}
+	resp.Write(conn)
+}
@@ -0,0 +1,120 @@
+// network.go - 网络切换处理
+//
+// 移动设备在 Wi-Fi 与蜂窝网络之间切换后，旧网络上的隧道不会收到 RST，要等到 Ping
+// 超时才会断开。宿主应用在 ConnectivityManager.NetworkCallback 中调用 OnNetworkChanged，
+// 引擎立即关闭旧隧道与共享的 HTTP/2、HTTP/3 连接，清除传输方式的失败记录，按配置
+// 重新查询 ECH 配置，然后按配置的传输顺序建立一条探测隧道：新网络上不可用的传输方式
+// 在探测时即被回退并记录，结果计入统计与 LastError。入站监听不受影响，客户端重连后即使用新网络。
+package proxyclient
+
+import (
+	"sync"
+)
+
+// NetworkNone 网络已断开 (传给 OnNetworkChanged 的 kind)
+const NetworkNone = "none"
+
+// tunnelSet 活动隧道集合
+type tunnelSet struct {
+	mu    sync.Mutex
+	conns map[*tunnelConn]struct{}
+}
+
+func (s *tunnelSet) add(t *tunnelConn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conns == nil {
+		s.conns = make(map[*tunnelConn]struct{})
+	}
+	s.conns[t] = struct{}{}
+}
+
+func (s *tunnelSet) remove(t *tunnelConn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.conns, t)
+}
+
+// closeAll 关闭所有活动隧道，返回关闭的数量
+func (s *tunnelSet) closeAll() int {
+	s.mu.Lock()
+	conns := make([]*tunnelConn, 0, len(s.conns))
+	for t := range s.conns {
+		conns = append(conns, t)
+	}
+	s.mu.Unlock()
+
+	for _, t := range conns {
+		t.Close()
+	}
+	return len(conns)
+}
+
+// OnNetworkChanged 通知引擎默认网络已变化。kind 为网络类型 (如 wifi / cellular /
+// ethernet，断网时为 none)，id 区分同类型的不同网络 (如 Android Network 的句柄)。
+// 与当前网络相同时忽略；启动后的第一次通知只记录网络，不断开连接。
+// 切换到 none 以外的网络后在后台探测服务端 (见 probeServer)。
+func (c *ProxyClient) OnNetworkChanged(kind, id string) {
+	network := kind
+	if id != "" {
+		network += "/" + id
+	}
+
+	c.mu.Lock()
+	previous := c.network
+	c.network = network
+	if !c.running || previous == "" || previous == network {
+		c.mu.Unlock()
+		return
+	}
+	c.closeForwardTransport()
+	c.mu.Unlock()
+
+	closed := c.tunnels.closeAll()
+	c.closeTransports()
+	c.resetTransportFailures()
+	c.logInfo("网络已切换: %s -> %s，已关闭 %d 条隧道", previous, network, closed)
+
+	if kind == NetworkNone {
+		return
+	}
+	refreshECH := c.refreshECHOnNetworkChange && !c.staticECH && c.echPolicy != ECHPolicyDisable
+	go func() {
+		if refreshECH {
+			if err := c.refreshECH(); err != nil {
+				c.logError("网络切换后刷新 ECH 配置失败: %v", err)
+			}
+		}
+		c.probeServer(network)
+	}()
+}
+
+// probeServer 按配置的传输顺序建立一条隧道，检测新网络上服务端是否可达后关闭。
+// 不可用的传输方式在此时被回退并记录失败，结果计入统计，失败原因记入 LastError；
+// 建立的共享 HTTP/2、HTTP/3 连接留给之后的隧道复用
+func (c *ProxyClient) probeServer(network string) {
+	if !c.IsRunning() {
+		return
+	}
+	defer c.stats.networkProbes.Add(1) // 探测结束后计数，失败次数先于探测次数更新
+	tunnel, err := c.dialWebSocketWithECH(c.timeouts.maxRetries)
+	if err != nil {
+		c.stats.networkProbeFailures.Add(1)
+		c.logError("网络 %s 上探测服务端失败: %v", network, err)
+		return
+	}
+	tunnel.Close()
+	c.logInfo("网络 %s 上探测服务端成功", network)
+}
+
+// resetTransportFailures 清除传输方式的失败记录，随后的探测重新尝试首选传输方式。
+// 协议版本的记录与网络无关，予以保留
+func (c *ProxyClient) resetTransportFailures() {
+	c.transports.mu.Lock()
+	defer c.transports.mu.Unlock()
+	for key := range c.transports.failed {
+		if key != ProtocolV1 {
+			delete(c.transports.failed, key)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+// pac.go - 在代理监听端口上提供 PAC 自动配置文件 (/proxy.pac)
+package proxyclient
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+)
+
+const pacPath = "/proxy.pac"
+
+// defaultPACBypass 未配置直连列表时直连的地址
+var defaultPACBypass = []string{
+	"localhost",
+	"*.local",
+	"127.0.0.0/8",
+	"10.0.0.0/8",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"169.254.0.0/16",
+}
+
+// validatePACBypass 校验直连列表：域名 (含子域名)、通配符、IP 或 IPv4 地址段
+func validatePACBypass(list []string) error {
+	for _, entry := range list {
+		if strings.Contains(entry, "/") {
+			ip, _, err := net.ParseCIDR(entry)
+			if err != nil {
+				return fmt.Errorf("无效的 PAC 直连地址段: %s", entry)
+			}
+			if ip.To4() == nil {
+				return fmt.Errorf("PAC 不支持 IPv6 地址段: %s", entry)
+			}
+			continue
+		}
+		if strings.ContainsAny(entry, " \"'\\") {
+			return fmt.Errorf("无效的 PAC 直连地址: %s", entry)
+		}
+	}
+	return nil
+}
+
+// generatePAC 生成 PAC 脚本，proxyAddr 为浏览器访问代理使用的 host:port，socks 表示该地址同时接受 SOCKS5
+func generatePAC(proxyAddr string, bypass []string, socks bool) string {
+	quote := func(s string) string {
+		data, _ := json.Marshal(s)
+		return string(data)
+	}
+
+	var b strings.Builder
+	b.WriteString("function FindProxyForURL(url, host) {\n")
+	b.WriteString("\tif (isPlainHostName(host)) return \"DIRECT\";\n")
+	b.WriteString("\tvar ipv4 = /^\\d+\\.\\d+\\.\\d+\\.\\d+$/.test(host);\n")
+	for _, entry := range bypass {
+		switch {
+		case strings.Contains(entry, "/"):
+			_, ipNet, err := net.ParseCIDR(entry)
+			if err != nil || ipNet.IP.To4() == nil {
+				continue
+			}
+			// 只对 IP 地址判断地址段，避免 isInNet 对域名发起 DNS 查询
+			fmt.Fprintf(&b, "\tif (ipv4 && isInNet(host, %s, %s)) return \"DIRECT\";\n",
+				quote(ipNet.IP.String()), quote(net.IP(ipNet.Mask).String()))
+		case strings.Contains(entry, "*"):
+			fmt.Fprintf(&b, "\tif (shExpMatch(host, %s)) return \"DIRECT\";\n", quote(entry))
+		case net.ParseIP(entry) != nil:
+			fmt.Fprintf(&b, "\tif (host == %s) return \"DIRECT\";\n", quote(entry))
+		default:
+			domain := strings.TrimPrefix(entry, ".")
+			fmt.Fprintf(&b, "\tif (host == %s || dnsDomainIs(host, %s)) return \"DIRECT\";\n",
+				quote(domain), quote("."+domain))
+		}
+	}
+	proxies := fmt.Sprintf("PROXY %s", proxyAddr)
+	if socks {
+		proxies = fmt.Sprintf("SOCKS5 %s; SOCKS %s; %s", proxyAddr, proxyAddr, proxies)
+	}
+	fmt.Fprintf(&b, "\treturn %s;\n", quote(proxies))
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// isPACRequest 是否为对本代理 /proxy.pac 的直接请求 (而非需要转发的绝对 URI 请求)
+func isPACRequest(req *http.Request) bool {
+	return !req.URL.IsAbs() && req.URL.Path == pacPath &&
+		(req.Method == http.MethodGet || req.Method == http.MethodHead)
+}
+
+// servePAC 返回 PAC 文件，返回连接是否可以继续处理下一个请求
+func (c *ProxyClient) servePAC(conn net.Conn, req *http.Request, clientAddr string, in *inboundListener) bool {
+	defer req.Body.Close()
+
+	proxyAddr := c.pacProxyAddr
+	if proxyAddr == "" {
+		proxyAddr = req.Host
+	}
+	if _, _, err := net.SplitHostPort(proxyAddr); err != nil || strings.ContainsAny(proxyAddr, " ;\"") {
+		writeSimpleResponse(conn, req, http.StatusBadRequest, "无效的 Host\n")
+		return false
+	}
+
+	c.logInfo("PAC: %s 获取 %s", clientAddr, pacPath)
+
+	script := generatePAC(proxyAddr, c.pacBypass, in.Protocol == InboundMixed)
+	resp := &http.Response{
+		StatusCode:    http.StatusOK,
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Request:       req,
+		Header:        http.Header{"Content-Type": {"application/x-ns-proxy-autoconfig"}, "Cache-Control": {"no-cache"}},
+		ContentLength: int64(len(script)),
+		Body:          io.NopCloser(strings.NewReader(script)),
+		Close:         req.Close,
+	}
+	if err := resp.Write(conn); err != nil {
+		return false
+	}
+	return !req.Close
+}
+
+// PACURL 返回 PAC 文件地址 (如 http://127.0.0.1:1080/proxy.pac)，未启用 PAC 或未运行时返回空字符串
+func (c *ProxyClient) PACURL() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.pac || len(c.listeners) == 0 {
+		return ""
+	}
+	host := c.pacProxyAddr
+	if host == "" {
+		// 使用第一个可接受 HTTP 请求的 TCP 入站
+		var addr *net.TCPAddr
+		for _, in := range c.listeners {
+			if tcpAddr, ok := in.listener.Addr().(*net.TCPAddr); ok && (in.Protocol == InboundMixed || in.Protocol == InboundHTTP) {
+				addr = tcpAddr
+				break
+			}
+		}
+		if addr == nil {
+			return ""
+		}
+		ip := addr.IP
+		if ip == nil || ip.IsUnspecified() {
+			ip = net.IPv4(127, 0, 0, 1)
+		}
+		host = net.JoinHostPort(ip.String(), fmt.Sprint(addr.Port))
+	}
+	return "http://" + host + pacPath
+}
@@ -0,0 +1,129 @@
+// protect.go - 出站套接字保护
+//
+// Android VpnService 捕获全部流量时，引擎自身到服务端、DNS 服务器和上游代理的连接
+// 也会被路由回 VPN 形成回环。设置 SocketProtector 后，引擎创建的每个出站套接字在
+// connect 之前都会调用 VpnService.protect(fd)，使其绕过 VPN。
+//
+// 此时域名解析不经过系统解析器：cgo 解析器由系统库创建套接字，无法保护；纯 Go 解析器
+// 依赖 /etc/resolv.conf，Android 上没有该文件，会回退到 localhost:53。因此解析请求
+// 一律发往配置的引导 DNS 服务器 (BootstrapDNS)，与 resolv.conf 无关。
+package proxyclient
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// defaultBootstrapDNS 未配置 BootstrapDNS 时使用的引导 DNS 服务器
+var defaultBootstrapDNS = []string{"223.5.5.5:53", "119.29.29.29:53"}
+
+// parseBootstrapDNS 解析逗号分隔的 IP[:端口] 列表，端口默认 53，列表为空时使用默认服务器
+func parseBootstrapDNS(list string) ([]string, error) {
+	var servers []string
+	for _, item := range splitList(list) {
+		if addr, err := netip.ParseAddr(strings.Trim(item, "[]")); err == nil {
+			servers = append(servers, netip.AddrPortFrom(addr, 53).String())
+			continue
+		}
+		addrPort, err := netip.ParseAddrPort(item)
+		if err != nil {
+			return nil, fmt.Errorf("无效的引导DNS服务器 (需为 IP 或 IP:端口): %s", item)
+		}
+		servers = append(servers, addrPort.String())
+	}
+	if len(servers) == 0 {
+		return defaultBootstrapDNS, nil
+	}
+	return servers, nil
+}
+
+// SocketProtector 出站套接字保护接口 (供 Java 实现，通常直接调用 VpnService.protect)
+type SocketProtector interface {
+	Protect(fd int) bool
+}
+
+// protectSocket 作为 net.Dialer/net.ListenConfig 的 Control 回调
+func (c *ProxyClient) protectSocket(network, address string, raw syscall.RawConn) error {
+	protector := c.protector
+	if protector == nil {
+		return nil
+	}
+	var ok bool
+	err := raw.Control(func(fd uintptr) {
+		ok = protector.Protect(int(fd))
+	})
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("保护套接字失败: %s %s", network, address)
+	}
+	return nil
+}
+
+// dialer 返回出站连接使用的 net.Dialer，设置了 SocketProtector 时保护每个套接字，
+// 包括解析域名时到引导 DNS 服务器的套接字
+func (c *ProxyClient) dialer() *net.Dialer {
+	dialer := c.timeouts.dialer()
+	if c.protector != nil {
+		dialer.Control = c.protectSocket
+		dialer.Resolver = c.resolver()
+	}
+	return dialer
+}
+
+// resolver 返回解析域名使用的 net.Resolver。设置了 SocketProtector 时使用纯 Go 解析器，
+// 忽略 resolv.conf 中的服务器地址，轮流连接引导 DNS 服务器，连接同样被保护
+func (c *ProxyClient) resolver() *net.Resolver {
+	if c.protector == nil {
+		return net.DefaultResolver
+	}
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			servers := c.bootstrapDNS
+			if len(servers) == 0 {
+				servers = defaultBootstrapDNS
+			}
+			// 解析器每次重试都会重新连接，轮转使失败的服务器不会被反复使用
+			server := servers[int(c.bootstrapNext.Add(1)-1)%len(servers)]
+			dialer := c.timeouts.dialer()
+			dialer.Control = c.protectSocket
+			return dialer.DialContext(ctx, network, server)
+		},
+	}
+}
+
+// resolveUDPAddr 解析 host:port (port 为数字)，与 net.ResolveUDPAddr 一样优先使用 IPv4 地址
+func (c *ProxyClient) resolveUDPAddr(ctx context.Context, host, port string) (*net.UDPAddr, error) {
+	portNum, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("无效的端口: %s", port)
+	}
+	addrs, err := c.resolver().LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return nil, err
+	}
+	addr := addrs[0]
+	for _, a := range addrs {
+		if a.Unmap().Is4() {
+			addr = a
+			break
+		}
+	}
+	return net.UDPAddrFromAddrPort(netip.AddrPortFrom(addr.Unmap(), uint16(portNum))), nil
+}
+
+// listenUDP 创建出站 UDP 套接字 (HTTP/3)，设置了 SocketProtector 时保护该套接字
+func (c *ProxyClient) listenUDP() (net.PacketConn, error) {
+	var lc net.ListenConfig
+	if c.protector != nil {
+		lc.Control = c.protectSocket
+	}
+	return lc.ListenPacket(context.Background(), "udp", ":0")
+}
@@ -0,0 +1,125 @@
+package proxyclient
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"sync/atomic"
+	"testing"
+)
+
+// countingProtector 记录被保护的套接字数量
+type countingProtector struct {
+	calls atomic.Int32
+}
+
+func (p *countingProtector) Protect(fd int) bool {
+	p.calls.Add(1)
+	return true
+}
+
+// startFakeDNS 在本地 UDP 端口上应答 A 查询，所有域名都解析为 ip
+func startFakeDNS(t *testing.T, ip net.IP) (addr string, queries *atomic.Int32) {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	queries = new(atomic.Int32)
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, from, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if n < 12 {
+				continue
+			}
+			queries.Add(1)
+			// 问题段之后附加一条指向问题名的 A 记录，AAAA 查询只返回空应答
+			end := 12
+			for end < n && buf[end] != 0 {
+				end += int(buf[end]) + 1
+			}
+			end += 5
+			if end > n {
+				continue
+			}
+			qtype := binary.BigEndian.Uint16(buf[end-4 : end-2])
+			resp := append([]byte(nil), buf[:end]...)
+			resp[2] |= 0x80 // QR
+			resp[3] = 0x80  // RA
+			if qtype == 1 {
+				resp[7] = 1 // ANCOUNT
+				resp = append(resp, 0xc0, 0x0c, 0x00, 0x01, 0x00, 0x01, 0x00, 0x00, 0x00, 0x3c, 0x00, 0x04)
+				resp = append(resp, ip.To4()...)
+			}
+			pc.WriteTo(resp, from)
+		}
+	}()
+	return pc.LocalAddr().String(), queries
+}
+
+func TestProtectedResolverWithoutResolvConf(t *testing.T) {
+	dnsAddr, queries := startFakeDNS(t, net.IPv4(192, 0, 2, 1))
+	bootstrap, err := parseBootstrapDNS(dnsAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	timeouts, err := newTimeoutConfig(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	protector := &countingProtector{}
+	c := &ProxyClient{protector: protector, bootstrapDNS: bootstrap, timeouts: timeouts}
+
+	// 没有 resolv.conf 时纯 Go 解析器回退到 localhost:53，连接仍应发往引导 DNS 服务器
+	conn, err := c.resolver().Dial(context.Background(), "udp", "127.0.0.1:53")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := conn.RemoteAddr().String(); got != dnsAddr {
+		t.Errorf("解析器连接了 %s，应为引导 DNS 服务器 %s", got, dnsAddr)
+	}
+	conn.Close()
+
+	addr, err := c.resolveUDPAddr(context.Background(), "server.example.test", "443")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr.String() != "192.0.2.1:443" {
+		t.Errorf("解析结果为 %s，应为 192.0.2.1:443", addr)
+	}
+	if queries.Load() == 0 {
+		t.Error("引导 DNS 服务器未收到查询")
+	}
+	if protector.calls.Load() == 0 {
+		t.Error("到 DNS 服务器的套接字未被保护")
+	}
+}
+
+func TestParseBootstrapDNS(t *testing.T) {
+	servers, err := parseBootstrapDNS("1.1.1.1, [2606:4700::1111]:5353,::1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"1.1.1.1:53", "[2606:4700::1111]:5353", "[::1]:53"}
+	if len(servers) != len(want) {
+		t.Fatalf("解析为 %v，应为 %v", servers, want)
+	}
+	for i := range want {
+		if servers[i] != want[i] {
+			t.Errorf("第 %d 个为 %s，应为 %s", i, servers[i], want[i])
+		}
+	}
+
+	if servers, _ := parseBootstrapDNS(""); len(servers) != len(defaultBootstrapDNS) {
+		t.Errorf("为空时应使用默认服务器，实际 %v", servers)
+	}
+	if _, err := parseBootstrapDNS("dns.example.com"); err == nil {
+		t.Error("域名应报错")
+	}
+}
@@ -0,0 +1,259 @@
+// protocol.go - 隧道协议编解码 (客户端与服务端共用)
+//
+// v0 为文本协议：
+//
+//	客户端 -> 服务端  文本 "CONNECT:host:port|首帧数据"
+//	服务端 -> 客户端  文本 "CONNECTED" 或 "ERROR:错误信息"
+//	双向数据          二进制消息
+//	关闭              文本 "CLOSE"
+//
+// v1 为二进制帧，每条消息均为二进制，格式为 [版本 1][类型 1][内容]：
+//
+//	CONNECT    [地址类型 1][地址][端口 2][首帧长度 4][首帧数据]，地址类型同 SOCKS5
+//	CONNECTED  无内容
+//	ERROR      [错误码 1][错误信息]，错误码取值见 ErrorCode* 常量
+//	DATA       [数据]
+//	CLOSE      无内容
+//	UDP        同 CONNECT (首帧长度为 0)，之后每条 DATA 为一个 UDP 数据报
+//
+// v1 通过 WebSocket 子协议 (或 gRPC/xhttp 的 X-Tunnel-Protocol 头) 协商。
+package proxyclient
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/gorilla/websocket"
+)
+
+// 隧道协议版本
+const (
+	ProtocolV0   = "v0"   // 文本协议，兼容现有服务端
+	ProtocolV1   = "v1"   // 二进制帧
+	ProtocolAuto = "auto" // 优先 v1，服务端不支持时使用 v0
+)
+
+// ProtocolV1Subprotocol v1 协议的 WebSocket 子协议名
+const ProtocolV1Subprotocol = "tunnel.v1"
+
+// TunnelProtocolHeader gRPC/xhttp 传输中协商协议版本的请求/响应头
+const TunnelProtocolHeader = "X-Tunnel-Protocol"
+
+// 隧道消息类型
+const (
+	FrameConnect   byte = 0x01
+	FrameConnected byte = 0x02
+	FrameError     byte = 0x03
+	FrameData      byte = 0x04
+	FrameClose     byte = 0x05
+	FrameUDP       byte = 0x06 // 仅 v1：建立 UDP 关联
+)
+
+const frameVersion1 = 0x01
+
+// TunnelMessage 解码后的隧道消息
+type TunnelMessage struct {
+	Type      byte   // 消息类型
+	Target    string // FrameConnect/FrameUDP: 目标地址 host:port
+	Payload   []byte // FrameConnect: 首帧数据；FrameData: 数据
+	ErrorCode byte   // FrameError: 错误码 (v0 中恒为 0)
+	Error     string // FrameError: 错误信息
+}
+
+// EncodeTunnelMessage 按协议版本编码消息，返回 WebSocket 消息类型和内容
+func EncodeTunnelMessage(version string, msg TunnelMessage) (int, []byte, error) {
+	switch version {
+	case ProtocolV0:
+		return encodeV0(msg)
+	case ProtocolV1:
+		data, err := encodeV1(msg)
+		return websocket.BinaryMessage, data, err
+	}
+	return 0, nil, fmt.Errorf("未知的协议版本: %s", version)
+}
+
+// DecodeTunnelMessage 按协议版本解码消息
+func DecodeTunnelMessage(version string, messageType int, data []byte) (TunnelMessage, error) {
+	switch version {
+	case ProtocolV0:
+		return decodeV0(messageType, data)
+	case ProtocolV1:
+		if messageType != websocket.BinaryMessage {
+			return TunnelMessage{}, errors.New("v1 协议只接受二进制消息")
+		}
+		return decodeV1(data)
+	}
+	return TunnelMessage{}, fmt.Errorf("未知的协议版本: %s", version)
+}
+
+// ======================== v0 ========================
+
+func encodeV0(msg TunnelMessage) (int, []byte, error) {
+	switch msg.Type {
+	case FrameConnect:
+		data := make([]byte, 0, len("CONNECT:|")+len(msg.Target)+len(msg.Payload))
+		data = append(data, "CONNECT:"...)
+		data = append(data, msg.Target...)
+		data = append(data, '|')
+		return websocket.TextMessage, append(data, msg.Payload...), nil
+	case FrameConnected:
+		return websocket.TextMessage, []byte("CONNECTED"), nil
+	case FrameError:
+		return websocket.TextMessage, []byte("ERROR:" + msg.Error), nil
+	case FrameData:
+		return websocket.BinaryMessage, msg.Payload, nil
+	case FrameClose:
+		return websocket.TextMessage, []byte("CLOSE"), nil
+	case FrameUDP:
+		return 0, nil, errors.New("v0 协议不支持 UDP")
+	}
+	return 0, nil, fmt.Errorf("未知的消息类型: 0x%02x", msg.Type)
+}
+
+func decodeV0(messageType int, data []byte) (TunnelMessage, error) {
+	if messageType != websocket.TextMessage {
+		return TunnelMessage{Type: FrameData, Payload: data}, nil
+	}
+
+	switch {
+	case bytes.HasPrefix(data, []byte("CONNECT:")):
+		rest := data[len("CONNECT:"):]
+		idx := bytes.IndexByte(rest, '|')
+		if idx < 0 {
+			return TunnelMessage{}, errors.New("CONNECT 消息格式错误")
+		}
+		return TunnelMessage{Type: FrameConnect, Target: string(rest[:idx]), Payload: rest[idx+1:]}, nil
+	case string(data) == "CONNECTED":
+		return TunnelMessage{Type: FrameConnected}, nil
+	case bytes.HasPrefix(data, []byte("ERROR:")):
+		return TunnelMessage{Type: FrameError, Error: string(data[len("ERROR:"):])}, nil
+	case string(data) == "CLOSE":
+		return TunnelMessage{Type: FrameClose}, nil
+	}
+	// 其它文本消息按数据处理
+	return TunnelMessage{Type: FrameData, Payload: data}, nil
+}
+
+// ======================== v1 ========================
+
+func encodeV1(msg TunnelMessage) ([]byte, error) {
+	switch msg.Type {
+	case FrameConnect, FrameUDP:
+		host, portStr, err := net.SplitHostPort(msg.Target)
+		if err != nil {
+			return nil, fmt.Errorf("无效的目标地址: %w", err)
+		}
+		port, err := strconv.ParseUint(portStr, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("无效的目标端口: %s", portStr)
+		}
+
+		frame := make([]byte, 0, 2+1+1+len(host)+2+4+len(msg.Payload))
+		frame = append(frame, frameVersion1, msg.Type)
+		if ip := net.ParseIP(host); ip != nil {
+			if ip4 := ip.To4(); ip4 != nil {
+				frame = append(frame, 0x01)
+				frame = append(frame, ip4...)
+			} else {
+				frame = append(frame, 0x04)
+				frame = append(frame, ip.To16()...)
+			}
+		} else {
+			if len(host) == 0 || len(host) > 255 {
+				return nil, fmt.Errorf("无效的目标域名: %q", host)
+			}
+			frame = append(frame, 0x03, byte(len(host)))
+			frame = append(frame, host...)
+		}
+		frame = binary.BigEndian.AppendUint16(frame, uint16(port))
+		frame = binary.BigEndian.AppendUint32(frame, uint32(len(msg.Payload)))
+		return append(frame, msg.Payload...), nil
+
+	case FrameConnected, FrameClose:
+		return []byte{frameVersion1, msg.Type}, nil
+
+	case FrameError:
+		frame := make([]byte, 0, 3+len(msg.Error))
+		frame = append(frame, frameVersion1, FrameError, msg.ErrorCode)
+		return append(frame, msg.Error...), nil
+
+	case FrameData:
+		frame := make([]byte, 2+len(msg.Payload))
+		frame[0], frame[1] = frameVersion1, FrameData
+		copy(frame[2:], msg.Payload)
+		return frame, nil
+	}
+	return nil, fmt.Errorf("未知的消息类型: 0x%02x", msg.Type)
+}
+
+func decodeV1(frame []byte) (TunnelMessage, error) {
+	if len(frame) < 2 {
+		return TunnelMessage{}, errors.New("v1 帧过短")
+	}
+	if frame[0] != frameVersion1 {
+		return TunnelMessage{}, fmt.Errorf("不支持的帧版本: %d", frame[0])
+	}
+
+	msg := TunnelMessage{Type: frame[1]}
+	body := frame[2:]
+	switch msg.Type {
+	case FrameConnect, FrameUDP:
+		if len(body) < 1 {
+			return TunnelMessage{}, errors.New("CONNECT 帧过短")
+		}
+		var host string
+		atyp := body[0]
+		body = body[1:]
+		switch atyp {
+		case 0x01, 0x04:
+			size := net.IPv4len
+			if atyp == 0x04 {
+				size = net.IPv6len
+			}
+			if len(body) < size {
+				return TunnelMessage{}, errors.New("CONNECT 帧过短")
+			}
+			host = net.IP(body[:size]).String()
+			body = body[size:]
+		case 0x03:
+			if len(body) < 1 || len(body) < 1+int(body[0]) {
+				return TunnelMessage{}, errors.New("CONNECT 帧过短")
+			}
+			host = string(body[1 : 1+body[0]])
+			body = body[1+body[0]:]
+		default:
+			return TunnelMessage{}, fmt.Errorf("未知的地址类型: 0x%02x", atyp)
+		}
+		if len(body) < 6 {
+			return TunnelMessage{}, errors.New("CONNECT 帧过短")
+		}
+		port := binary.BigEndian.Uint16(body)
+		length := binary.BigEndian.Uint32(body[2:])
+		body = body[6:]
+		if uint32(len(body)) != length {
+			return TunnelMessage{}, fmt.Errorf("首帧长度不符: 声明 %d，实际 %d", length, len(body))
+		}
+		msg.Target = net.JoinHostPort(host, strconv.Itoa(int(port)))
+		msg.Payload = body
+
+	case FrameConnected, FrameClose:
+
+	case FrameError:
+		if len(body) < 1 {
+			return TunnelMessage{}, errors.New("ERROR 帧过短")
+		}
+		msg.ErrorCode = body[0]
+		msg.Error = string(body[1:])
+
+	case FrameData:
+		msg.Payload = body
+
+	default:
+		return TunnelMessage{}, fmt.Errorf("未知的帧类型: 0x%02x", msg.Type)
+	}
+	return msg, nil
+}
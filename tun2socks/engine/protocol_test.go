@@ -0,0 +1,143 @@
+package proxyclient
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+// binaryPayload 含 '|'、NUL 与非 UTF-8 字节的数据
+var binaryPayload = []byte{'|', 0x00, 0xff, 0xfe, '|', 0x80, 'C', 'L', 'O', 'S', 'E', 0xc3, 0x28}
+
+func TestV1RoundTrip(t *testing.T) {
+	messages := []TunnelMessage{
+		{Type: FrameConnect, Target: "93.184.216.34:443"},
+		{Type: FrameConnect, Target: "93.184.216.34:80", Payload: binaryPayload},
+		{Type: FrameConnect, Target: "[2001:db8::1]:443", Payload: binaryPayload},
+		{Type: FrameConnect, Target: "example.com:8443", Payload: []byte("GET / HTTP/1.1\r\n\r\n")},
+		{Type: FrameConnect, Target: "a|b.example:1", Payload: binaryPayload},
+		{Type: FrameUDP, Target: "8.8.8.8:53"},
+		{Type: FrameUDP, Target: "[2001:4860:4860::8888]:53"},
+		{Type: FrameUDP, Target: "dns.google:853"},
+		{Type: FrameConnected},
+		{Type: FrameClose},
+		{Type: FrameData, Payload: binaryPayload},
+		{Type: FrameData},
+		{Type: FrameError},
+		{Type: FrameError, ErrorCode: ErrorCodeAuthFailed, Error: "认证失败"},
+		{Type: FrameError, ErrorCode: ErrorCodeTargetRefused, Error: string(binaryPayload)},
+		{Type: FrameError, ErrorCode: ErrorCodeBlocked},
+		{Type: FrameError, ErrorCode: 0xff, Error: "未知错误码也原样保留"},
+	}
+
+	for _, want := range messages {
+		messageType, frame, err := EncodeTunnelMessage(ProtocolV1, want)
+		if err != nil {
+			t.Fatalf("编码 %+v: %v", want, err)
+		}
+		if messageType != websocket.BinaryMessage {
+			t.Errorf("编码 %+v: 消息类型 %d，应为二进制", want, messageType)
+		}
+		got, err := DecodeTunnelMessage(ProtocolV1, messageType, frame)
+		if err != nil {
+			t.Fatalf("解码 %+v: %v", want, err)
+		}
+		if !equalMessage(got, want) {
+			t.Errorf("往返不一致:\n got  %+v\n want %+v", got, want)
+		}
+	}
+}
+
+func TestV1DecodeTruncated(t *testing.T) {
+	// 声明了长度的帧，任何截断都应报错，而不是返回残缺的消息
+	messages := []TunnelMessage{
+		{Type: FrameConnect, Target: "93.184.216.34:443", Payload: binaryPayload},
+		{Type: FrameConnect, Target: "[2001:db8::1]:443", Payload: binaryPayload},
+		{Type: FrameConnect, Target: "example.com:443", Payload: binaryPayload},
+		{Type: FrameUDP, Target: "[2001:db8::1]:53"},
+		{Type: FrameUDP, Target: "example.com:53"},
+	}
+	for _, msg := range messages {
+		frame, err := encodeV1(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for n := 0; n < len(frame); n++ {
+			if got, err := decodeV1(frame[:n]); err == nil {
+				t.Errorf("%s 截断到 %d/%d 字节应报错，得到 %+v", msg.Target, n, len(frame), got)
+			}
+		}
+		if _, err := decodeV1(append(frame, 0)); err == nil {
+			t.Errorf("%s 末尾多出的字节应报错", msg.Target)
+		}
+	}
+
+	invalid := map[string][]byte{
+		"空帧":         {},
+		"只有版本":       {frameVersion1},
+		"ERROR 无错误码": {frameVersion1, FrameError},
+		"未知版本":       {0x02, FrameData},
+		"未知类型":       {frameVersion1, 0x7f},
+		"未知地址类型":     {frameVersion1, FrameConnect, 0x05, 0, 0, 0, 0, 0, 0},
+	}
+	for name, frame := range invalid {
+		if got, err := decodeV1(frame); err == nil {
+			t.Errorf("%s: 应报错，得到 %+v", name, got)
+		}
+	}
+}
+
+func TestV1EncodeInvalid(t *testing.T) {
+	invalid := []TunnelMessage{
+		{Type: FrameConnect, Target: "example.com"},
+		{Type: FrameConnect, Target: "example.com:70000"},
+		{Type: FrameConnect, Target: ":443"},
+		{Type: FrameUDP, Target: string(bytes.Repeat([]byte("a"), 256)) + ":53"},
+		{Type: 0x7f},
+	}
+	for _, msg := range invalid {
+		if _, err := encodeV1(msg); err == nil {
+			t.Errorf("编码 %+v 应报错", msg)
+		}
+	}
+}
+
+func TestV0RoundTrip(t *testing.T) {
+	messages := []TunnelMessage{
+		// 首帧数据中的 '|' 不影响解析：只按第一个 '|' 分隔目标
+		{Type: FrameConnect, Target: "example.com:443", Payload: binaryPayload},
+		{Type: FrameConnect, Target: "[2001:db8::1]:443"},
+		{Type: FrameConnected},
+		{Type: FrameClose},
+		{Type: FrameData, Payload: binaryPayload},
+		{Type: FrameError, Error: "连接被拒绝"},
+	}
+	for _, want := range messages {
+		messageType, data, err := EncodeTunnelMessage(ProtocolV0, want)
+		if err != nil {
+			t.Fatalf("编码 %+v: %v", want, err)
+		}
+		got, err := DecodeTunnelMessage(ProtocolV0, messageType, data)
+		if err != nil {
+			t.Fatalf("解码 %+v: %v", want, err)
+		}
+		if !equalMessage(got, want) {
+			t.Errorf("往返不一致:\n got  %+v\n want %+v", got, want)
+		}
+	}
+
+	if _, _, err := EncodeTunnelMessage(ProtocolV0, TunnelMessage{Type: FrameUDP, Target: "8.8.8.8:53"}); err == nil {
+		t.Error("v0 不支持 UDP，编码应报错")
+	}
+	// 二进制消息即使内容像控制消息也按数据处理
+	if got, _ := DecodeTunnelMessage(ProtocolV0, websocket.BinaryMessage, []byte("CLOSE")); got.Type != FrameData {
+		t.Errorf("二进制 CLOSE 解码为类型 0x%02x，应为数据", got.Type)
+	}
+}
+
+// equalMessage 比较消息，nil 与空的 Payload 视为相同
+func equalMessage(a, b TunnelMessage) bool {
+	return a.Type == b.Type && a.Target == b.Target && bytes.Equal(a.Payload, b.Payload) &&
+		a.ErrorCode == b.ErrorCode && a.Error == b.Error
+}
@@ -0,0 +1,282 @@
+// ratelimit.go - 上传/下载限速
+//
+// 令牌桶以预留方式扣减：读到数据后预留相应字节数，令牌不足时按欠额等待，先预留者先获得令牌。
+// 全局令牌桶按差额轮转 (deficit round robin) 在连接间公平分配：连接每轮预留 fairQuantum 字节
+// 的额度并排到队尾，额度用完后再预留下一轮，持续占满带宽的连接按字节平分全局带宽，
+// 与每次读取的大小无关。全局限速与单连接限速同时生效，按目标、入站或应用的规则覆盖单连接限速。
+package proxyclient
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fairQuantum 全局限速每轮为连接预留的字节数，即连接间轮转的粒度
+const fairQuantum = 8 * 1024
+
+// rateLimiter 令牌桶，容量为一秒的速率
+type rateLimiter struct {
+	rate float64 // 字节/秒
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// newRateLimiter 创建速率为 bytesPerSecond 的令牌桶，速率不大于 0 时返回 nil (不限制)
+func newRateLimiter(bytesPerSecond int64) *rateLimiter {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	return &rateLimiter{rate: float64(bytesPerSecond), tokens: float64(bytesPerSecond), last: time.Now()}
+}
+
+// bytesPerSecond 返回速率，nil 返回 0
+func (l *rateLimiter) bytesPerSecond() int64 {
+	if l == nil {
+		return 0
+	}
+	return int64(l.rate)
+}
+
+// reserve 预留 n 字节，返回需要等待的时间
+func (l *rateLimiter) reserve(n int) time.Duration {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.rate {
+		l.tokens = l.rate
+	}
+	l.last = now
+
+	l.tokens -= float64(n)
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// rateRule 按目标覆盖单连接限速
+type rateRule struct {
+	scope    ruleScope
+	any      bool   // 目标为 *，匹配所有目标
+	domain   string // 匹配域名及其子域名
+	prefix   netip.Prefix
+	upload   int64
+	download int64
+}
+
+func (r rateRule) matches(host string, src connSource) bool {
+	if !r.scope.matches(src) {
+		return false
+	}
+	if r.any {
+		return true
+	}
+	if addr, err := netip.ParseAddr(strings.Trim(host, "[]")); err == nil {
+		return r.prefix.IsValid() && r.prefix.Contains(addr.Unmap())
+	}
+	if r.domain == "" {
+		return false
+	}
+	domain := strings.TrimSuffix(strings.ToLower(host), ".")
+	return domain == r.domain || strings.HasSuffix(domain, "."+r.domain)
+}
+
+// rateLimits 限速配置
+type rateLimits struct {
+	upload       *rateLimiter // 全局
+	download     *rateLimiter
+	connUpload   int64 // 单连接 (字节/秒)
+	connDownload int64
+	rules        []rateRule
+}
+
+// newRateLimits 根据全局、单连接限速和按目标的规则创建限速配置，都未设置时返回 nil
+func newRateLimits(upload, download, connUpload, connDownload int64, rules []string) (*rateLimits, error) {
+	for _, v := range []int64{upload, download, connUpload, connDownload} {
+		if v < 0 {
+			return nil, fmt.Errorf("限速不能为负数: %d", v)
+		}
+	}
+	limits := &rateLimits{
+		upload:       newRateLimiter(upload),
+		download:     newRateLimiter(download),
+		connUpload:   connUpload,
+		connDownload: connDownload,
+	}
+	for _, line := range rules {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		rule, err := parseRateRule(line)
+		if err != nil {
+			return nil, err
+		}
+		limits.rules = append(limits.rules, rule)
+	}
+	if upload == 0 && download == 0 && connUpload == 0 && connDownload == 0 && len(limits.rules) == 0 {
+		return nil, nil
+	}
+	return limits, nil
+}
+
+func (l *rateLimits) String() string {
+	return fmt.Sprintf("全局 上传 %s / 下载 %s，单连接 上传 %s / 下载 %s，%d 条目标规则",
+		formatRate(l.upload.bytesPerSecond()), formatRate(l.download.bytesPerSecond()),
+		formatRate(l.connUpload), formatRate(l.connDownload), len(l.rules))
+}
+
+// formatRate 速率的日志格式
+func formatRate(bytesPerSecond int64) string {
+	switch {
+	case bytesPerSecond == 0:
+		return "不限"
+	case bytesPerSecond%(1024*1024) == 0:
+		return strconv.FormatInt(bytesPerSecond/(1024*1024), 10) + " MB/s"
+	case bytesPerSecond%1024 == 0:
+		return strconv.FormatInt(bytesPerSecond/1024, 10) + " KB/s"
+	}
+	return strconv.FormatInt(bytesPerSecond, 10) + " B/s"
+}
+
+// parseRateRule 解析 "[inbound:<标签>] [app:<包名>] 目标 上传 下载"，目标为域名、IP、CIDR 或 * (所有目标)，
+// 速率为字节/秒 (可带 K/M 后缀，0 不限制)
+func parseRateRule(line string) (rateRule, error) {
+	scope, rest, err := parseRuleScope(line)
+	if err != nil {
+		return rateRule{}, err
+	}
+	fields := strings.Fields(rest)
+	if len(fields) != 3 {
+		return rateRule{}, fmt.Errorf("无效的限速规则: %s (格式: [inbound:标签] [app:包名] 目标 上传 下载)", line)
+	}
+
+	rule := rateRule{scope: scope}
+	if fields[0] == "*" {
+		rule.any = true
+	} else if prefix, err := netip.ParsePrefix(fields[0]); err == nil {
+		rule.prefix = prefix.Masked()
+	} else if addr, err := netip.ParseAddr(fields[0]); err == nil {
+		rule.prefix = netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen())
+	} else if rule.domain = validDomain(strings.TrimPrefix(fields[0], "*.")); rule.domain == "" {
+		return rateRule{}, fmt.Errorf("无效的限速规则目标: %s", fields[0])
+	}
+
+	if rule.upload, err = parseRate(fields[1]); err != nil {
+		return rateRule{}, err
+	}
+	if rule.download, err = parseRate(fields[2]); err != nil {
+		return rateRule{}, err
+	}
+	return rule, nil
+}
+
+// parseRate 解析速率 (字节/秒)，支持 K、M 后缀 (1024 进制)
+func parseRate(s string) (int64, error) {
+	digits, multiplier := s, int64(1)
+	switch {
+	case strings.HasSuffix(s, "K") || strings.HasSuffix(s, "k"):
+		digits, multiplier = s[:len(s)-1], 1024
+	case strings.HasSuffix(s, "M") || strings.HasSuffix(s, "m"):
+		digits, multiplier = s[:len(s)-1], 1024*1024
+	}
+	v, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("无效的速率: %s", s)
+	}
+	return v * multiplier, nil
+}
+
+// connLimiter 单条连接的限速，nil 表示不限制。每个方向只由一个 goroutine 等待
+type connLimiter struct {
+	limits   *rateLimits
+	upload   *rateLimiter
+	download *rateLimiter
+
+	uploadCredit   int // 本轮已从全局令牌桶预留、尚未使用的字节数
+	downloadCredit int
+}
+
+// newConnLimiter 为来自 src、到 target (host:port) 的连接创建限速，未配置限速时返回 nil
+func (c *ProxyClient) newConnLimiter(target string, src connSource) *connLimiter {
+	limits := c.rateLimits
+	if limits == nil {
+		return nil
+	}
+	upload, download := limits.connUpload, limits.connDownload
+	host, _, err := net.SplitHostPort(target)
+	if err != nil {
+		host = target
+	}
+	for _, rule := range limits.rules {
+		if rule.matches(host, src) {
+			upload, download = rule.upload, rule.download
+			break
+		}
+	}
+	return &connLimiter{
+		limits:   limits,
+		upload:   newRateLimiter(upload),
+		download: newRateLimiter(download),
+	}
+}
+
+// waitUpload 等待上传 n 字节所需的令牌，done 关闭 (隧道关闭或代理停止) 时提前返回
+func (l *connLimiter) waitUpload(n int, done <-chan struct{}) {
+	if l == nil {
+		return
+	}
+	waitFair(l.limits.upload, l.upload, &l.uploadCredit, n, done)
+}
+
+// waitDownload 等待下载 n 字节所需的令牌，done 关闭时提前返回。
+// 等待期间隧道不读取消息，Pong 超时检测不计这段时间 (见 tunnelConn.ping)
+func (l *connLimiter) waitDownload(n int, done <-chan struct{}) {
+	if l == nil {
+		return
+	}
+	waitFair(l.limits.download, l.download, &l.downloadCredit, n, done)
+}
+
+// waitFair 等待全局与单连接令牌桶都能放行 n 字节。单连接令牌一次预留；全局令牌在
+// 本轮额度不足时按 fairQuantum 逐轮预留，每轮等待结束后才预留下一轮，其间其它连接的预留排在前面
+func waitFair(global, conn *rateLimiter, credit *int, n int, done <-chan struct{}) {
+	deadline := time.Now().Add(conn.reserve(n))
+	if global != nil {
+		for *credit < n {
+			if !sleepDone(global.reserve(fairQuantum), done) {
+				return
+			}
+			*credit += fairQuantum
+		}
+		*credit -= n
+	}
+	sleepDone(time.Until(deadline), done)
+}
+
+// sleepDone 休眠 d，done 关闭时提前返回 false
+func sleepDone(d time.Duration, done <-chan struct{}) bool {
+	if d <= 0 {
+		return true
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-done:
+		return false
+	}
+}
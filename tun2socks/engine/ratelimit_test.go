@@ -0,0 +1,206 @@
+package proxyclient
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterReserve(t *testing.T) {
+	limiter := newRateLimiter(100 * 1024)
+
+	// 令牌桶初始为满，一秒的量可以立即通过
+	if d := limiter.reserve(100 * 1024); d != 0 {
+		t.Errorf("桶满时预留应立即通过，等待 %v", d)
+	}
+	// 之后按欠额等待：再预留 50 KB 需要约 0.5 秒，继续预留的排在其后
+	if d := limiter.reserve(50 * 1024); d < 450*time.Millisecond || d > 550*time.Millisecond {
+		t.Errorf("欠 50 KB 应等待约 0.5s，实际 %v", d)
+	}
+	if d := limiter.reserve(50 * 1024); d < 950*time.Millisecond || d > 1050*time.Millisecond {
+		t.Errorf("欠 100 KB 应等待约 1s，实际 %v", d)
+	}
+
+	if newRateLimiter(0) != nil {
+		t.Error("速率为 0 时不限制，应返回 nil")
+	}
+	if d := (*rateLimiter)(nil).reserve(1 << 20); d != 0 {
+		t.Errorf("nil 令牌桶不应等待，实际 %v", d)
+	}
+}
+
+func TestRateRuleMatches(t *testing.T) {
+	tests := []struct {
+		rule  string
+		host  string
+		src   connSource
+		match bool
+	}{
+		{"* 1K 2K", "example.com", connSource{}, true},
+		{"* 1K 2K", "10.0.0.1", connSource{inbound: "lan"}, true},
+		{"example.com 1K 2K", "example.com", connSource{}, true},
+		{"example.com 1K 2K", "cdn.Example.com.", connSource{}, true},
+		{"example.com 1K 2K", "badexample.com", connSource{}, false},
+		{"*.example.com 1K 2K", "a.example.com", connSource{}, true},
+		{"10.0.0.0/8 1K 2K", "10.1.2.3", connSource{}, true},
+		{"10.0.0.0/8 1K 2K", "11.0.0.1", connSource{}, false},
+		{"10.0.0.0/8 1K 2K", "::ffff:10.0.0.1", connSource{}, true},
+		{"2001:db8::/32 1K 2K", "[2001:db8::1]", connSource{}, true},
+		{"192.168.1.1 1K 2K", "192.168.1.1", connSource{}, true},
+		{"192.168.1.1 1K 2K", "192.168.1.2", connSource{}, false},
+		{"10.0.0.0/8 1K 2K", "example.com", connSource{}, false},
+		{"example.com 1K 2K", "10.0.0.1", connSource{}, false},
+		{"inbound:lan * 1K 2K", "example.com", connSource{inbound: "lan"}, true},
+		{"inbound:lan * 1K 2K", "example.com", connSource{inbound: "wan"}, false},
+		{"app:com.example.video * 1K 2K", "example.com", connSource{app: "com.example.video"}, true},
+		{"app:com.example.video * 1K 2K", "example.com", connSource{app: "com.other,com.example.video"}, true},
+		{"app:com.example.video * 1K 2K", "example.com", connSource{app: "com.other"}, false},
+		{"app:com.example.video * 1K 2K", "example.com", connSource{}, false},
+		{"inbound:tproxy app:com.example.video example.com 1K 2K", "example.com", connSource{inbound: "tproxy", app: "com.example.video"}, true},
+		{"inbound:tproxy app:com.example.video example.com 1K 2K", "example.com", connSource{inbound: "lan", app: "com.example.video"}, false},
+	}
+	for _, tt := range tests {
+		rule, err := parseRateRule(tt.rule)
+		if err != nil {
+			t.Fatalf("解析 %q: %v", tt.rule, err)
+		}
+		if got := rule.matches(tt.host, tt.src); got != tt.match {
+			t.Errorf("%q 匹配 %s (%+v) = %v，应为 %v", tt.rule, tt.host, tt.src, got, tt.match)
+		}
+	}
+
+	rule, err := parseRateRule("example.com 512K 2M")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rule.upload != 512*1024 || rule.download != 2*1024*1024 {
+		t.Errorf("速率解析为 %d/%d", rule.upload, rule.download)
+	}
+
+	for _, line := range []string{"example.com 1K", "example.com 1K 2K 3K", "example.com -1 0", "example.com 1X 0", "bad_domain! 1K 1K", "inbound: * 1K 1K"} {
+		if _, err := parseRateRule(line); err == nil {
+			t.Errorf("%q 应报错", line)
+		}
+	}
+}
+
+func TestNewConnLimiterRules(t *testing.T) {
+	limits, err := newRateLimits(0, 0, 10*1024, 20*1024, []string{
+		"# 注释",
+		"inbound:lan * 0 0",
+		"video.example 1K 2K",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &ProxyClient{rateLimits: limits}
+
+	// 第一条匹配的规则覆盖单连接限速，未匹配时使用默认的单连接限速
+	tests := []struct {
+		target           string
+		src              connSource
+		upload, download int64
+	}{
+		{"video.example:443", connSource{inbound: "lan"}, 0, 0},
+		{"video.example:443", connSource{inbound: "wan"}, 1024, 2048},
+		{"cdn.video.example:443", connSource{}, 1024, 2048},
+		{"other.example:443", connSource{}, 10 * 1024, 20 * 1024},
+	}
+	for _, tt := range tests {
+		l := c.newConnLimiter(tt.target, tt.src)
+		if got := l.upload.bytesPerSecond(); got != tt.upload {
+			t.Errorf("%s (%+v) 上传限速 %d，应为 %d", tt.target, tt.src, got, tt.upload)
+		}
+		if got := l.download.bytesPerSecond(); got != tt.download {
+			t.Errorf("%s (%+v) 下载限速 %d，应为 %d", tt.target, tt.src, got, tt.download)
+		}
+	}
+
+	if (&ProxyClient{}).newConnLimiter("example.com:443", connSource{}) != nil {
+		t.Error("未配置限速时应返回 nil")
+	}
+}
+
+func TestWaitCombinesLimits(t *testing.T) {
+	// 全局与单连接限速同时生效，等待时间取较长者：无论哪一个较慢，15 KB 都要在 10 KB/s 下等待约 0.5s
+	tests := []struct {
+		name         string
+		global, conn int64
+	}{
+		{"单连接较慢", 1 << 20, 10 * 1024},
+		{"全局较慢", 10 * 1024, 1 << 20},
+	}
+	for _, tt := range tests {
+		limits, err := newRateLimits(0, tt.global, 0, tt.conn, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		l := (&ProxyClient{rateLimits: limits}).newConnLimiter("example.com:443", connSource{})
+
+		start := time.Now()
+		l.waitDownload(15*1024, nil)
+		if elapsed := time.Since(start); elapsed < 400*time.Millisecond || elapsed > time.Second {
+			t.Errorf("%s: 下载 15 KB 等待了 %v，应约为 0.5s", tt.name, elapsed)
+		}
+	}
+}
+
+func TestWaitFairShare(t *testing.T) {
+	// 两条连接共享 256 KB/s 的全局限速，一条每次读 32 KB，一条每次读 4 KB，
+	// 按片轮转后两者获得的字节数应接近，而不是与读取大小成正比
+	limits, err := newRateLimits(0, 256*1024, 0, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	limits.download.reserve(256 * 1024) // 清空初始令牌，只比较限速后的份额
+	c := &ProxyClient{rateLimits: limits}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	var big, small atomic.Int64
+	for _, flow := range []struct {
+		chunk int
+		total *atomic.Int64
+	}{{32 * 1024, &big}, {4 * 1024, &small}} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			l := c.newConnLimiter("example.com:443", connSource{})
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				l.waitDownload(flow.chunk, done)
+				flow.total.Add(int64(flow.chunk))
+			}
+		}()
+	}
+	time.Sleep(time.Second)
+	close(done)
+	wg.Wait()
+
+	ratio := float64(big.Load()) / float64(small.Load())
+	t.Logf("大块连接 %d 字节，小块连接 %d 字节", big.Load(), small.Load())
+	if ratio > 2 || ratio < 0.5 {
+		t.Errorf("大块连接 %d 字节，小块连接 %d 字节，份额比 %.2f 不公平", big.Load(), small.Load(), ratio)
+	}
+	if total := big.Load() + small.Load(); total > 400*1024 {
+		t.Errorf("一秒内通过 %d 字节，超过全局限速", total)
+	}
+}
+
+func TestWaitDownloadInterrupted(t *testing.T) {
+	// 1 KB/s 的单连接限速，下载 10 KB 需要等待约 9 秒
+	limiter := &connLimiter{limits: &rateLimits{}, download: newRateLimiter(1024)}
+	done := make(chan struct{})
+	time.AfterFunc(50*time.Millisecond, func() { close(done) })
+
+	start := time.Now()
+	limiter.waitDownload(10*1024, done)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("隧道关闭后限速等待仍持续了 %v", elapsed)
+	}
+}
@@ -0,0 +1,170 @@
+// ech.go - 服务端 ECH 密钥的生成、保存与加载
+//
+// 密钥文件采用 PEM 格式 (与 OpenSSL ECH 相同)：一个 "PRIVATE KEY" 块 (PKCS#8 X25519 私钥)
+// 和一个 "ECHCONFIG" 块 (ECHConfigList)。ECHConfigList 的 base64 即客户端 ECHConfigList 配置项。
+package server
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+
+	proxyclient "github.com/yourusername/proxyclient"
+)
+
+const (
+	echVersion     = 0xfe0d
+	hpkeKEMX25519  = 0x0020
+	hpkeKDFSHA256  = 0x0001
+	hpkeAEADAES128 = 0x0001
+	hpkeAEADChaCha = 0x0003
+
+	pemTypePrivateKey = "PRIVATE KEY"
+	pemTypeECHConfig  = "ECHCONFIG"
+)
+
+// ECHKey 服务端 ECH 密钥
+type ECHKey struct {
+	Config     []byte // 单个 ECHConfig
+	PrivateKey []byte // X25519 私钥
+}
+
+// GenerateECHKey 为指定的外层 SNI (public name) 生成新的 X25519 ECH 密钥
+func GenerateECHKey(publicName string, configID uint8) (*ECHKey, error) {
+	if publicName == "" || len(publicName) > 255 {
+		return nil, fmt.Errorf("无效的 ECH public name: %q", publicName)
+	}
+
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("生成 X25519 密钥失败: %w", err)
+	}
+	publicKey := key.PublicKey().Bytes()
+
+	contents := []byte{configID}
+	contents = binary.BigEndian.AppendUint16(contents, hpkeKEMX25519)
+	contents = binary.BigEndian.AppendUint16(contents, uint16(len(publicKey)))
+	contents = append(contents, publicKey...)
+	contents = binary.BigEndian.AppendUint16(contents, 8)
+	contents = binary.BigEndian.AppendUint16(contents, hpkeKDFSHA256)
+	contents = binary.BigEndian.AppendUint16(contents, hpkeAEADAES128)
+	contents = binary.BigEndian.AppendUint16(contents, hpkeKDFSHA256)
+	contents = binary.BigEndian.AppendUint16(contents, hpkeAEADChaCha)
+	contents = append(contents, 0, byte(len(publicName)))
+	contents = append(contents, publicName...)
+	contents = binary.BigEndian.AppendUint16(contents, 0)
+
+	config := binary.BigEndian.AppendUint16(nil, echVersion)
+	config = binary.BigEndian.AppendUint16(config, uint16(len(contents)))
+	config = append(config, contents...)
+
+	return &ECHKey{Config: config, PrivateKey: key.Bytes()}, nil
+}
+
+// ConfigList 返回只包含本密钥的 ECHConfigList
+func (k *ECHKey) ConfigList() []byte {
+	list := binary.BigEndian.AppendUint16(nil, uint16(len(k.Config)))
+	return append(list, k.Config...)
+}
+
+// ConfigListBase64 返回 ECHConfigList 的 base64，可直接填入客户端配置或 DNS HTTPS 记录
+func (k *ECHKey) ConfigListBase64() string {
+	return base64.StdEncoding.EncodeToString(k.ConfigList())
+}
+
+// MarshalPEM 将密钥编码为 PEM
+func (k *ECHKey) MarshalPEM() ([]byte, error) {
+	priv, err := ecdh.X25519().NewPrivateKey(k.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("无效的 ECH 私钥: %w", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return nil, fmt.Errorf("编码 ECH 私钥失败: %w", err)
+	}
+
+	data := pem.EncodeToMemory(&pem.Block{Type: pemTypePrivateKey, Bytes: der})
+	return append(data, pem.EncodeToMemory(&pem.Block{Type: pemTypeECHConfig, Bytes: k.ConfigList()})...), nil
+}
+
+// SaveECHKey 将密钥写入文件 (权限 0600)
+func SaveECHKey(path string, key *ECHKey) error {
+	data, err := key.MarshalPEM()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
+
+// ParseECHKey 解析 PEM 格式的 ECH 密钥
+func ParseECHKey(data []byte) (*ECHKey, error) {
+	var privDER, configList []byte
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		switch block.Type {
+		case pemTypePrivateKey:
+			privDER = block.Bytes
+		case pemTypeECHConfig:
+			configList = block.Bytes
+		}
+	}
+	if privDER == nil || configList == nil {
+		return nil, errors.New("ECH 密钥文件缺少 PRIVATE KEY 或 ECHCONFIG")
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(privDER)
+	if err != nil {
+		return nil, fmt.Errorf("解析 ECH 私钥失败: %w", err)
+	}
+	priv, ok := parsed.(*ecdh.PrivateKey)
+	if !ok || priv.Curve() != ecdh.X25519() {
+		return nil, errors.New("ECH 私钥必须为 X25519")
+	}
+
+	if err := proxyclient.ValidateECHConfigList(configList); err != nil {
+		return nil, err
+	}
+	if len(configList) < 2 || int(binary.BigEndian.Uint16(configList)) != len(configList)-2 {
+		return nil, errors.New("ECHConfigList 长度不符")
+	}
+	// 密钥文件只对应一个 ECHConfig
+	config := configList[2:]
+	if len(config) < 4 || int(binary.BigEndian.Uint16(config[2:]))+4 != len(config) {
+		return nil, errors.New("ECH 密钥文件只能包含一个 ECHConfig")
+	}
+
+	return &ECHKey{Config: config, PrivateKey: priv.Bytes()}, nil
+}
+
+// LoadECHKey 从文件加载 ECH 密钥
+func LoadECHKey(path string) (*ECHKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取 ECH 密钥文件失败: %w", err)
+	}
+	return ParseECHKey(data)
+}
+
+// tlsKeys 转换为 crypto/tls 使用的密钥列表，所有密钥都作为拒绝时的 retry_configs 下发
+func tlsKeys(keys []*ECHKey) []tls.EncryptedClientHelloKey {
+	result := make([]tls.EncryptedClientHelloKey, 0, len(keys))
+	for _, k := range keys {
+		result = append(result, tls.EncryptedClientHelloKey{
+			Config:      k.Config,
+			PrivateKey:  k.PrivateKey,
+			SendAsRetry: true,
+		})
+	}
+	return result
+}
@@ -0,0 +1,438 @@
+// server.go - 隧道协议的参考服务端实现
+//
+// 实现与 Cloudflare Worker 相同的 WebSocket 隧道协议 (HTTP/1.1 升级)，用于自建服务端
+// 和在本机对 ProxyClient 做端到端测试：
+//   - 认证：令牌通过 WebSocket 子协议 (Sec-WebSocket-Protocol) 传递
+//   - 协议：v0 文本协议 CONNECT:/CONNECTED/ERROR:/CLOSE，客户端提供 tunnel.v1 子协议时使用 v1 二进制帧
+//   - 握手中的 CONNECT：握手请求头中的 CONNECT 请求
+//   - TLS：可选证书与 ECH 密钥，不配置证书时以明文 HTTP 提供服务 (用于反向代理之后或测试)
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	proxyclient "github.com/yourusername/proxyclient"
+)
+
+const (
+	defaultDialTimeout      = 10 * time.Second
+	defaultHandshakeTimeout = 30 * time.Second
+	udpIdleTimeout          = 60 * time.Second
+)
+
+// Config 服务端配置
+type Config struct {
+	Path  string // WebSocket 路径，默认 "/"
+	Token string // 认证令牌 (WebSocket 子协议)，为空则不认证
+
+	CertFile     string            // TLS 证书文件
+	KeyFile      string            // TLS 私钥文件
+	Certificates []tls.Certificate // 直接提供的 TLS 证书 (优先于 CertFile/KeyFile)
+	ECHKeys      []*ECHKey         // ECH 密钥 (需要配置 TLS 证书)
+	ECHKeyFiles  []string          // ECH 密钥文件 (PEM)
+
+	DisableV1 bool // 只使用 v0 文本协议
+
+	DialTimeout      time.Duration // 连接目标超时，默认 10s
+	HandshakeTimeout time.Duration // 等待 CONNECT 请求超时，默认 30s
+}
+
+// Server 隧道服务端
+type Server struct {
+	path             string
+	token            string
+	tlsConfig        *tls.Config
+	disableV1        bool
+	dialTimeout      time.Duration
+	handshakeTimeout time.Duration
+	upgrader         websocket.Upgrader
+	logCallback      func(level, message string)
+
+	httpServer *http.Server
+	listener   net.Listener
+	tunnels    map[*websocket.Conn]struct{}
+	running    bool
+	stopped    bool
+	mu         sync.Mutex
+}
+
+// NewServer 创建服务端
+func NewServer(config Config) (*Server, error) {
+	if config.Path == "" {
+		config.Path = "/"
+	}
+	if config.DialTimeout < 0 || config.HandshakeTimeout < 0 {
+		return nil, errors.New("超时时间不能为负数")
+	}
+	if config.DialTimeout == 0 {
+		config.DialTimeout = defaultDialTimeout
+	}
+	if config.HandshakeTimeout == 0 {
+		config.HandshakeTimeout = defaultHandshakeTimeout
+	}
+
+	tlsConfig, err := buildTLSConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		path:             config.Path,
+		token:            config.Token,
+		tlsConfig:        tlsConfig,
+		disableV1:        config.DisableV1,
+		dialTimeout:      config.DialTimeout,
+		handshakeTimeout: config.HandshakeTimeout,
+		tunnels:          make(map[*websocket.Conn]struct{}),
+	}
+	s.upgrader = websocket.Upgrader{
+		HandshakeTimeout: config.HandshakeTimeout,
+		CheckOrigin:      func(r *http.Request) bool { return true },
+	}
+	return s, nil
+}
+
+// buildTLSConfig 根据证书与 ECH 密钥构建 TLS 配置，未配置证书时返回 nil
+func buildTLSConfig(config Config) (*tls.Config, error) {
+	certs := config.Certificates
+	if len(certs) == 0 && config.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("加载 TLS 证书失败: %w", err)
+		}
+		certs = []tls.Certificate{cert}
+	}
+
+	echKeys := config.ECHKeys
+	for _, path := range config.ECHKeyFiles {
+		key, err := LoadECHKey(path)
+		if err != nil {
+			return nil, err
+		}
+		echKeys = append(echKeys, key)
+	}
+
+	if len(certs) == 0 {
+		if len(echKeys) > 0 {
+			return nil, errors.New("使用 ECH 需要配置 TLS 证书")
+		}
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: certs,
+		MinVersion:   tls.VersionTLS12,
+		// 只支持 HTTP/1.1 升级，HTTP/2 客户端会在 ALPN 阶段回退
+		NextProtos: []string{"http/1.1"},
+	}
+	if len(echKeys) > 0 {
+		tlsConfig.MinVersion = tls.VersionTLS13
+		tlsConfig.EncryptedClientHelloKeys = tlsKeys(echKeys)
+	}
+	return tlsConfig, nil
+}
+
+// SetLogCallback 设置日志回调
+func (s *Server) SetLogCallback(callback func(level, message string)) {
+	s.logCallback = callback
+}
+
+// logInfo 记录信息日志
+func (s *Server) logInfo(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	if s.logCallback != nil {
+		s.logCallback("INFO", msg)
+	} else {
+		log.Printf("[INFO] %s", msg)
+	}
+}
+
+// logError 记录错误日志
+func (s *Server) logError(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	if s.logCallback != nil {
+		s.logCallback("ERROR", msg)
+	} else {
+		log.Printf("[ERROR] %s", msg)
+	}
+}
+
+// Start 在 listenAddr 上启动服务端
+func (s *Server) Start(listenAddr string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.running {
+		return errors.New("服务端已在运行")
+	}
+
+	listener, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		return fmt.Errorf("监听失败: %w", err)
+	}
+
+	s.httpServer = &http.Server{
+		Handler:           s,
+		ReadHeaderTimeout: s.handshakeTimeout,
+		// 禁用 net/http 内置的 HTTP/2
+		TLSNextProto: map[string]func(*http.Server, *tls.Conn, http.Handler){},
+	}
+	s.listener = listener
+	s.running = true
+	s.stopped = false
+
+	scheme := "ws"
+	if s.tlsConfig != nil {
+		scheme = "wss"
+		listener = tls.NewListener(listener, s.tlsConfig)
+	}
+	go func(server *http.Server) {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.logError("服务端异常退出: %v", err)
+		}
+	}(s.httpServer)
+
+	s.logInfo("服务端启动: %s://%s%s", scheme, s.listener.Addr(), s.path)
+	if s.tlsConfig != nil && len(s.tlsConfig.EncryptedClientHelloKeys) > 0 {
+		s.logInfo("已启用 ECH (%d 个密钥)", len(s.tlsConfig.EncryptedClientHelloKeys))
+	}
+	return nil
+}
+
+// Stop 停止服务端并关闭所有隧道
+func (s *Server) Stop() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.running {
+		return errors.New("服务端未运行")
+	}
+
+	s.running = false
+	s.stopped = true
+	s.httpServer.Close()
+	s.httpServer = nil
+	s.listener = nil
+	for conn := range s.tunnels {
+		conn.Close()
+	}
+
+	s.logInfo("服务端已停止")
+	return nil
+}
+
+// Addr 返回监听地址，未运行时返回 nil
+func (s *Server) Addr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+// ServeHTTP 处理 WebSocket 隧道请求，也可挂载到已有的 HTTP 服务上
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != s.path {
+		http.NotFound(w, r)
+		return
+	}
+	if !websocket.IsWebSocketUpgrade(r) {
+		http.Error(w, "需要 WebSocket 升级", http.StatusBadRequest)
+		return
+	}
+
+	protocols := websocket.Subprotocols(r)
+	if s.token != "" && !slices.Contains(protocols, s.token) {
+		s.logError("认证失败: %s", r.RemoteAddr)
+		http.Error(w, "认证失败", http.StatusUnauthorized)
+		return
+	}
+
+	version := proxyclient.ProtocolV0
+	respHeader := http.Header{}
+	switch {
+	case !s.disableV1 && slices.Contains(protocols, proxyclient.ProtocolV1Subprotocol):
+		version = proxyclient.ProtocolV1
+		respHeader.Set("Sec-WebSocket-Protocol", proxyclient.ProtocolV1Subprotocol)
+	case s.token != "":
+		respHeader.Set("Sec-WebSocket-Protocol", s.token)
+	}
+
+	conn, err := s.upgrader.Upgrade(w, r, respHeader)
+	if err != nil {
+		s.logError("WebSocket 升级失败 %s: %v", r.RemoteAddr, err)
+		return
+	}
+
+	if !s.track(conn) {
+		conn.Close()
+		return
+	}
+	defer s.untrack(conn)
+
+	if err := s.handleTunnel(conn, version, r.RemoteAddr); err != nil {
+		s.logError("隧道失败 %s: %v", r.RemoteAddr, err)
+	}
+}
+
+// track 记录活动隧道，Stop 之后返回 false
+func (s *Server) track(conn *websocket.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stopped {
+		return false
+	}
+	s.tunnels[conn] = struct{}{}
+	return true
+}
+
+func (s *Server) untrack(conn *websocket.Conn) {
+	s.mu.Lock()
+	delete(s.tunnels, conn)
+	s.mu.Unlock()
+}
+
+// handleTunnel 处理一条隧道：读取 CONNECT、连接目标并双向转发
+func (s *Server) handleTunnel(conn *websocket.Conn, version string, clientAddr string) error {
+	defer conn.Close()
+
+	tunnel := &tunnelConn{conn: conn, version: version}
+
+	conn.SetReadDeadline(time.Now().Add(s.handshakeTimeout))
+	connect, err := tunnel.receive()
+	if err != nil {
+		return fmt.Errorf("读取 CONNECT 失败: %w", err)
+	}
+	conn.SetReadDeadline(time.Time{})
+	if connect.Type != proxyclient.FrameConnect && connect.Type != proxyclient.FrameUDP {
+		return fmt.Errorf("意外的消息类型: 0x%02x", connect.Type)
+	}
+
+	// UDP 关联中每条 DATA 为一个数据报，目标连接按读写刷新空闲截止时间
+	network := "tcp"
+	udp := connect.Type == proxyclient.FrameUDP
+	if udp {
+		network = "udp"
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.dialTimeout)
+	dialer := net.Dialer{}
+	target, err := dialer.DialContext(ctx, network, connect.Target)
+	cancel()
+	if err != nil {
+		tunnel.send(proxyclient.TunnelMessage{
+			Type:      proxyclient.FrameError,
+			ErrorCode: byte(proxyclient.ClassifyTargetError(err)),
+			Error:     err.Error(),
+		})
+		return fmt.Errorf("连接目标 %s 失败: %w", connect.Target, err)
+	}
+	defer target.Close()
+
+	if len(connect.Payload) > 0 {
+		if _, err := target.Write(connect.Payload); err != nil {
+			tunnel.send(proxyclient.TunnelMessage{
+				Type:      proxyclient.FrameError,
+				ErrorCode: byte(proxyclient.ClassifyTargetError(err)),
+				Error:     err.Error(),
+			})
+			return fmt.Errorf("写入首帧失败: %w", err)
+		}
+	}
+
+	if err := tunnel.send(proxyclient.TunnelMessage{Type: proxyclient.FrameConnected}); err != nil {
+		return err
+	}
+
+	s.logInfo("已连接: %s -> %s %s (协议 %s)", clientAddr, network, connect.Target, version)
+
+	touch := func() {
+		if udp {
+			target.SetReadDeadline(time.Now().Add(udpIdleTimeout))
+		}
+	}
+	touch()
+
+	done := make(chan bool, 2)
+
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, err := target.Read(buf)
+			touch()
+			if err != nil {
+				tunnel.send(proxyclient.TunnelMessage{Type: proxyclient.FrameClose})
+				done <- true
+				return
+			}
+			if err := tunnel.send(proxyclient.TunnelMessage{Type: proxyclient.FrameData, Payload: buf[:n]}); err != nil {
+				done <- true
+				return
+			}
+		}
+	}()
+
+	go func() {
+		for {
+			msg, err := tunnel.receive()
+			if err != nil {
+				done <- true
+				return
+			}
+
+			switch msg.Type {
+			case proxyclient.FrameClose:
+				done <- true
+				return
+			case proxyclient.FrameData:
+				touch()
+				if _, err := target.Write(msg.Payload); err != nil {
+					done <- true
+					return
+				}
+			}
+		}
+	}()
+
+	<-done
+	s.logInfo("已断开: %s -> %s", clientAddr, connect.Target)
+	return nil
+}
+
+// tunnelConn 按协商的协议版本收发隧道消息
+type tunnelConn struct {
+	conn    *websocket.Conn
+	version string
+	writeMu sync.Mutex
+}
+
+func (t *tunnelConn) send(msg proxyclient.TunnelMessage) error {
+	messageType, data, err := proxyclient.EncodeTunnelMessage(t.version, msg)
+	if err != nil {
+		return err
+	}
+	t.writeMu.Lock()
+	defer t.writeMu.Unlock()
+	return t.conn.WriteMessage(messageType, data)
+}
+
+func (t *tunnelConn) receive() (proxyclient.TunnelMessage, error) {
+	messageType, data, err := t.conn.ReadMessage()
+	if err != nil {
+		return proxyclient.TunnelMessage{}, err
+	}
+	return proxyclient.DecodeTunnelMessage(t.version, messageType, data)
+}
@@ -0,0 +1,219 @@
+// session.go - TLS 会话复用缓存 (PSK)
+//
+// 会话票据在 crypto/tls 与 uTLS 连接之间共享并可持久化，重连时以 PSK 恢复会话，省去证书交换与验证。
+// TLS 1.3 0-RTT 早期数据未实现：crypto/tls 与 uTLS 的客户端都不支持发送早期数据。
+package proxyclient
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	utls "github.com/refraction-networking/utls"
+)
+
+const (
+	sessionCacheCapacity = 64
+	sessionSaveInterval  = 30 * time.Second
+)
+
+// sessionEntry 序列化后的会话票据
+type sessionEntry struct {
+	Ticket []byte    `json:"ticket"`
+	State  []byte    `json:"state"`
+	Stored time.Time `json:"stored"`
+}
+
+// sessionStore 在 crypto/tls 与 uTLS 之间共享的会话缓存，可持久化到文件
+type sessionStore struct {
+	mu       sync.Mutex
+	entries  map[string]sessionEntry
+	path     string
+	dirty    bool
+	lastSave time.Time
+}
+
+func newSessionStore(path string) *sessionStore {
+	return &sessionStore{
+		entries: make(map[string]sessionEntry),
+		path:    path,
+	}
+}
+
+func (s *sessionStore) setPath(path string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.path = path
+}
+
+func (s *sessionStore) get(key string) (sessionEntry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.entries[key]
+	return entry, ok
+}
+
+func (s *sessionStore) put(key string, entry *sessionEntry) {
+	s.mu.Lock()
+	if entry == nil {
+		delete(s.entries, key)
+	} else {
+		s.entries[key] = *entry
+		if len(s.entries) > sessionCacheCapacity {
+			s.evictOldestLocked()
+		}
+	}
+	s.dirty = true
+	saveNow := s.path != "" && time.Since(s.lastSave) > sessionSaveInterval
+	s.mu.Unlock()
+
+	// Put 在握手读取路径上被调用，写文件放到后台
+	if saveNow {
+		go s.flush()
+	}
+}
+
+func (s *sessionStore) evictOldestLocked() {
+	var oldestKey string
+	var oldest time.Time
+	for key, entry := range s.entries {
+		if oldestKey == "" || entry.Stored.Before(oldest) {
+			oldestKey, oldest = key, entry.Stored
+		}
+	}
+	delete(s.entries, oldestKey)
+}
+
+// load 从文件加载会话缓存
+func (s *sessionStore) load() error {
+	s.mu.Lock()
+	path := s.path
+	s.mu.Unlock()
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	entries := make(map[string]sessionEntry)
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.entries = entries
+	s.mu.Unlock()
+	return nil
+}
+
+// flush 将有改动的会话缓存写入文件
+func (s *sessionStore) flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.path == "" || !s.dirty {
+		return nil
+	}
+	data, err := json.Marshal(s.entries)
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(filepath.Dir(s.path), "."+filepath.Base(s.path)+".tmp")
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	s.dirty = false
+	s.lastSave = time.Now()
+	return nil
+}
+
+// stdSessionCache crypto/tls 的 ClientSessionCache 适配
+type stdSessionCache struct {
+	store *sessionStore
+}
+
+func (c stdSessionCache) Get(key string) (*tls.ClientSessionState, bool) {
+	entry, ok := c.store.get(key)
+	if !ok {
+		return nil, false
+	}
+	state, err := tls.ParseSessionState(entry.State)
+	if err != nil {
+		return nil, false
+	}
+	session, err := tls.NewResumptionState(entry.Ticket, state)
+	if err != nil {
+		return nil, false
+	}
+	return session, true
+}
+
+func (c stdSessionCache) Put(key string, cs *tls.ClientSessionState) {
+	if cs == nil {
+		c.store.put(key, nil)
+		return
+	}
+	ticket, state, err := cs.ResumptionState()
+	if err != nil || state == nil {
+		return
+	}
+	raw, err := state.Bytes()
+	if err != nil {
+		return
+	}
+	c.store.put(key, &sessionEntry{Ticket: ticket, State: raw, Stored: time.Now()})
+}
+
+// utlsSessionCache uTLS 的 ClientSessionCache 适配
+type utlsSessionCache struct {
+	store *sessionStore
+}
+
+func (c utlsSessionCache) Get(key string) (*utls.ClientSessionState, bool) {
+	entry, ok := c.store.get(key)
+	if !ok {
+		return nil, false
+	}
+	state, err := utls.ParseSessionState(entry.State)
+	if err != nil {
+		return nil, false
+	}
+	session, err := utls.NewResumptionState(entry.Ticket, state)
+	if err != nil {
+		return nil, false
+	}
+	return session, true
+}
+
+func (c utlsSessionCache) Put(key string, cs *utls.ClientSessionState) {
+	if cs == nil {
+		c.store.put(key, nil)
+		return
+	}
+	ticket, state, err := cs.ResumptionState()
+	if err != nil || state == nil {
+		return
+	}
+	raw, err := state.Bytes()
+	if err != nil {
+		return
+	}
+	c.store.put(key, &sessionEntry{Ticket: ticket, State: raw, Stored: time.Now()})
+}
+
+// recordHandshake 统计 TLS 握手及会话复用情况
+func (c *ProxyClient) recordHandshake(resumed bool) {
+	c.stats.tlsHandshakes.Add(1)
+	if resumed {
+		c.stats.tlsResumed.Add(1)
+	}
+}
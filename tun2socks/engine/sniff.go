@@ -0,0 +1,222 @@
+// sniff.go - 从客户端首包嗅探目标域名 (TLS ClientHello SNI / HTTP Host)
+//
+// 客户端以 IP 地址指定目标时 (SOCKS5 atyp 0x01/0x04、透明代理)，用嗅探到的域名替换
+// CONNECT 目标，使服务端按域名解析和路由。
+package proxyclient
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	sniffTimeout  = 300 * time.Millisecond
+	maxSniffBytes = 16384
+)
+
+// isIPTarget 目标是否为 IP 地址
+func isIPTarget(target string) bool {
+	host, _, err := net.SplitHostPort(target)
+	return err == nil && net.ParseIP(host) != nil
+}
+
+// readSniffFrame 读取客户端首包，TLS 记录或 HTTP 头部不完整时 (如分成多个报文段的 ClientHello) 继续读取直到超时
+func readSniffFrame(conn net.Conn, timeout time.Duration) []byte {
+	_ = conn.SetReadDeadline(time.Now().Add(timeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	buffer := make([]byte, maxSniffBytes)
+	total := 0
+	for total < len(buffer) {
+		n, err := conn.Read(buffer[total:])
+		total += n
+		if err != nil || !(incompleteTLSRecord(buffer[:total]) || incompleteHTTPHeader(buffer[:total])) {
+			break
+		}
+	}
+	if total > 0 {
+		return buffer[:total]
+	}
+	return nil
+}
+
+// incompleteTLSRecord 数据是否为尚未读完的 TLS 握手记录
+func incompleteTLSRecord(data []byte) bool {
+	if len(data) == 0 || data[0] != 0x16 {
+		return false
+	}
+	if len(data) < 5 {
+		return true
+	}
+	return len(data) < 5+int(binary.BigEndian.Uint16(data[3:5]))
+}
+
+// incompleteHTTPHeader 数据是否为尚未读完头部的 HTTP 请求
+func incompleteHTTPHeader(data []byte) bool {
+	method, _, found := bytes.Cut(data, []byte(" "))
+	if !found || len(method) == 0 || len(method) > 16 {
+		return false
+	}
+	for _, b := range method {
+		if b < 'A' || b > 'Z' {
+			return false
+		}
+	}
+	return !bytes.Contains(data, []byte("\r\n\r\n"))
+}
+
+// sniffDomain 从首包中提取域名，无法识别时返回空字符串
+func sniffDomain(data []byte) string {
+	if domain := sniffTLSServerName(data); domain != "" {
+		return domain
+	}
+	return sniffHTTPHost(data)
+}
+
+// sniffTLSServerName 解析 TLS ClientHello 的 server_name 扩展
+func sniffTLSServerName(data []byte) string {
+	// TLS 记录头: type(1) version(2) length(2)
+	if len(data) < 5 || data[0] != 0x16 {
+		return ""
+	}
+	record := data[5:]
+	if length := int(binary.BigEndian.Uint16(data[3:5])); length < len(record) {
+		record = record[:length]
+	}
+
+	// 握手消息头: type(1) length(3)，ClientHello 为 1
+	if len(record) < 4 || record[0] != 0x01 {
+		return ""
+	}
+	hello := record[4:]
+
+	// version(2) random(32)
+	if len(hello) < 34 {
+		return ""
+	}
+	hello = hello[34:]
+
+	var ok bool
+	// session_id<0..32>
+	if hello, ok = skipVector(hello, 1); !ok {
+		return ""
+	}
+	// cipher_suites<2..2^16-2>
+	if hello, ok = skipVector(hello, 2); !ok {
+		return ""
+	}
+	// compression_methods<1..2^8-1>
+	if hello, ok = skipVector(hello, 1); !ok {
+		return ""
+	}
+
+	if len(hello) < 2 {
+		return ""
+	}
+	extensions := hello[2:]
+	if length := int(binary.BigEndian.Uint16(hello)); length < len(extensions) {
+		extensions = extensions[:length]
+	}
+
+	for len(extensions) >= 4 {
+		extType := binary.BigEndian.Uint16(extensions)
+		extLen := int(binary.BigEndian.Uint16(extensions[2:]))
+		extensions = extensions[4:]
+		if extLen > len(extensions) {
+			return ""
+		}
+		ext := extensions[:extLen]
+		extensions = extensions[extLen:]
+		if extType != 0 {
+			continue
+		}
+
+		// server_name_list<1..2^16-1>: name_type(1) host_name<1..2^16-1>
+		if len(ext) < 2 {
+			return ""
+		}
+		list := ext[2:]
+		for len(list) >= 3 {
+			nameType := list[0]
+			nameLen := int(binary.BigEndian.Uint16(list[1:]))
+			list = list[3:]
+			if nameLen > len(list) {
+				return ""
+			}
+			if nameType == 0 {
+				return validDomain(string(list[:nameLen]))
+			}
+			list = list[nameLen:]
+		}
+		return ""
+	}
+	return ""
+}
+
+// skipVector 跳过长度前缀为 lengthBytes 字节的向量
+func skipVector(data []byte, lengthBytes int) ([]byte, bool) {
+	if len(data) < lengthBytes {
+		return nil, false
+	}
+	length := 0
+	for _, b := range data[:lengthBytes] {
+		length = length<<8 | int(b)
+	}
+	data = data[lengthBytes:]
+	if length > len(data) {
+		return nil, false
+	}
+	return data[length:], true
+}
+
+// sniffHTTPHost 解析 HTTP/1.x 请求的 Host 头部
+func sniffHTTPHost(data []byte) string {
+	lineEnd := bytes.Index(data, []byte("\r\n"))
+	if lineEnd < 0 {
+		return ""
+	}
+	requestLine := strings.Fields(string(data[:lineEnd]))
+	if len(requestLine) != 3 || !strings.HasPrefix(requestLine[2], "HTTP/1.") {
+		return ""
+	}
+
+	headers := data[lineEnd+2:]
+	for len(headers) > 0 {
+		end := bytes.Index(headers, []byte("\r\n"))
+		if end <= 0 {
+			return ""
+		}
+		name, value, found := strings.Cut(string(headers[:end]), ":")
+		if found && strings.EqualFold(strings.TrimSpace(name), "Host") {
+			host := strings.TrimSpace(value)
+			if h, _, err := net.SplitHostPort(host); err == nil {
+				host = h
+			}
+			return validDomain(host)
+		}
+		headers = headers[end+2:]
+	}
+	return ""
+}
+
+// validDomain 转为小写并校验域名，不是合法域名 (含 IP 地址) 时返回空字符串
+func validDomain(domain string) string {
+	domain = strings.TrimSuffix(strings.ToLower(domain), ".")
+	if domain == "" || len(domain) > 253 || net.ParseIP(domain) != nil {
+		return ""
+	}
+	for _, label := range strings.Split(domain, ".") {
+		if label == "" || len(label) > 63 {
+			return ""
+		}
+		for _, r := range label {
+			if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+				return ""
+			}
+		}
+	}
+	return domain
+}
@@ -0,0 +1,53 @@
+// stats.go - 运行统计
+package proxyclient
+
+import (
+	"encoding/json"
+	"sync/atomic"
+)
+
+// clientStats 运行期间累计的计数器
+type clientStats struct {
+	tlsHandshakes atomic.Int64
+	tlsResumed    atomic.Int64
+	blocked       atomic.Int64
+
+	networkProbes        atomic.Int64 // 网络切换后的服务端探测
+	networkProbeFailures atomic.Int64
+}
+
+// Stats 统计信息快照
+type Stats struct {
+	TLSHandshakes     int64   `json:"tls_handshakes"`
+	TLSResumed        int64   `json:"tls_resumed"`
+	TLSResumptionRate float64 `json:"tls_resumption_rate"`
+	Blocked           int64   `json:"blocked"`
+
+	NetworkProbes        int64 `json:"network_probes"`
+	NetworkProbeFailures int64 `json:"network_probe_failures"`
+}
+
+// Stats 获取统计信息
+func (c *ProxyClient) Stats() Stats {
+	s := Stats{
+		TLSHandshakes: c.stats.tlsHandshakes.Load(),
+		TLSResumed:    c.stats.tlsResumed.Load(),
+		Blocked:       c.stats.blocked.Load(),
+
+		NetworkProbes:        c.stats.networkProbes.Load(),
+		NetworkProbeFailures: c.stats.networkProbeFailures.Load(),
+	}
+	if s.TLSHandshakes > 0 {
+		s.TLSResumptionRate = float64(s.TLSResumed) / float64(s.TLSHandshakes)
+	}
+	return s
+}
+
+// StatsJSON 以 JSON 格式获取统计信息
+func (c *ProxyClient) StatsJSON() string {
+	data, err := json.Marshal(c.Stats())
+	if err != nil {
+		return "{}"
+	}
+	return string(data)
+}
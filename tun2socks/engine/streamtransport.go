@@ -0,0 +1,228 @@
+// streamtransport.go - 非 WebSocket 传输: gRPC 双向流与 HTTP/2 流式 POST (xhttp)
+package proxyclient
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// 默认 gRPC 服务名，请求路径为 /<服务名>/Tun
+const defaultGRPCServiceName = "proxyclient.Tunnel"
+
+// framedConn 在 HTTP/2 流上收发带长度前缀的消息帧
+type framedConn struct {
+	stream  *h2Stream
+	r       *bufio.Reader
+	writeMu sync.Mutex
+}
+
+func newFramedConn(stream *h2Stream) framedConn {
+	return framedConn{stream: stream, r: bufio.NewReaderSize(stream, 32*1024)}
+}
+
+func (f *framedConn) writeFrame(frame []byte) error {
+	f.writeMu.Lock()
+	defer f.writeMu.Unlock()
+	_, err := f.stream.Write(frame)
+	return err
+}
+
+// readFrame 读取 headerLen 字节的帧头和 payload，长度位于帧头最后 4 字节
+func (f *framedConn) readFrame(headerLen int) ([]byte, []byte, error) {
+	header := make([]byte, headerLen)
+	if _, err := io.ReadFull(f.r, header); err != nil {
+		return nil, nil, err
+	}
+	length := binary.BigEndian.Uint32(header[headerLen-4:])
+	if length > wsMaxMessageSize {
+		return nil, nil, fmt.Errorf("消息过大: %d", length)
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(f.r, payload); err != nil {
+		return nil, nil, err
+	}
+	return header, payload, nil
+}
+
+func (f *framedConn) Close() error {
+	return f.stream.Close()
+}
+
+// bearerHeader 生成携带令牌和协议版本的请求头部
+func (c *ProxyClient) bearerHeader(req dialRequest) http.Header {
+	header := http.Header{}
+	if c.token != "" {
+		header.Set("Authorization", "Bearer "+c.token)
+	}
+	if req.offersV1() {
+		header.Set(TunnelProtocolHeader, ProtocolV1Subprotocol)
+	}
+	return header
+}
+
+// ======================== gRPC ========================
+
+// grpcTransport 使用 gRPC 双向流承载隧道。每条消息编码为
+//
+//	message Hunk { bytes data = 1; uint32 type = 2; }
+//
+// type 为 WebSocket 消息类型，二进制消息省略该字段。
+type grpcTransport struct{ c *ProxyClient }
+
+func (t grpcTransport) dial(tlsCfg *tls.Config, req dialRequest) (messageConn, http.Header, error) {
+	stream, err := t.c.openHTTP2Stream(tlsCfg, req.host, req.port, "/"+t.c.grpcServiceName+"/Tun", func(r *http.Request) {
+		r.Header = t.c.bearerHeader(req)
+		r.Header.Set("Content-Type", "application/grpc")
+		r.Header.Set("TE", "trailers")
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("gRPC 连接失败: %w", err)
+	}
+	if contentType := stream.resp.Header.Get("Content-Type"); contentType != "application/grpc" && !strings.HasPrefix(contentType, "application/grpc+") {
+		stream.Close()
+		return nil, nil, fmt.Errorf("gRPC 连接失败: 意外的 Content-Type %q", contentType)
+	}
+	return &grpcConn{framedConn: newFramedConn(stream)}, stream.resp.Header, nil
+}
+
+type grpcConn struct {
+	framedConn
+}
+
+func (g *grpcConn) WriteMessage(messageType int, data []byte) error {
+	// HTTP/2 连接自身有 PING 保活
+	if messageType == websocket.PingMessage || messageType == websocket.PongMessage {
+		return nil
+	}
+
+	hunk := make([]byte, 0, len(data)+16)
+	hunk = append(hunk, 0x0A)
+	hunk = binary.AppendUvarint(hunk, uint64(len(data)))
+	hunk = append(hunk, data...)
+	if messageType != websocket.BinaryMessage {
+		hunk = append(hunk, 0x10)
+		hunk = binary.AppendUvarint(hunk, uint64(messageType))
+	}
+
+	frame := make([]byte, 5, 5+len(hunk))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(hunk)))
+	return g.writeFrame(append(frame, hunk...))
+}
+
+func (g *grpcConn) ReadMessage() (int, []byte, error) {
+	header, payload, err := g.readFrame(5)
+	if err != nil {
+		if err == io.EOF {
+			err = g.status()
+		}
+		return 0, nil, err
+	}
+	if header[0] != 0 {
+		return 0, nil, errors.New("gRPC: 不支持压缩消息")
+	}
+	return decodeHunk(payload)
+}
+
+// status 在流结束时根据 trailer 中的 grpc-status 返回错误
+func (g *grpcConn) status() error {
+	trailer := g.stream.resp.Trailer
+	if code := trailer.Get("Grpc-Status"); code != "" && code != "0" {
+		return fmt.Errorf("gRPC 错误 %s: %s", code, trailer.Get("Grpc-Message"))
+	}
+	return io.EOF
+}
+
+// decodeHunk 解析 Hunk 消息，忽略未知字段
+func decodeHunk(b []byte) (int, []byte, error) {
+	messageType := websocket.BinaryMessage
+	var data []byte
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return 0, nil, errors.New("gRPC: 消息格式错误")
+		}
+		b = b[n:]
+
+		switch key & 7 {
+		case 0:
+			value, n := binary.Uvarint(b)
+			if n <= 0 {
+				return 0, nil, errors.New("gRPC: 消息格式错误")
+			}
+			b = b[n:]
+			if key>>3 == 2 {
+				messageType = int(value)
+			}
+		case 2:
+			length, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < length {
+				return 0, nil, errors.New("gRPC: 消息格式错误")
+			}
+			if key>>3 == 1 {
+				data = append(data, b[n:n+int(length)]...)
+			}
+			b = b[n+int(length):]
+		case 1:
+			if len(b) < 8 {
+				return 0, nil, errors.New("gRPC: 消息格式错误")
+			}
+			b = b[8:]
+		case 5:
+			if len(b) < 4 {
+				return 0, nil, errors.New("gRPC: 消息格式错误")
+			}
+			b = b[4:]
+		default:
+			return 0, nil, errors.New("gRPC: 消息格式错误")
+		}
+	}
+	return messageType, data, nil
+}
+
+// ======================== xhttp ========================
+
+// xhttpTransport 使用 HTTP/2 流式 POST 承载隧道，上下行均为
+// [类型 1 字节][长度 4 字节][数据] 的帧序列
+type xhttpTransport struct{ c *ProxyClient }
+
+func (t xhttpTransport) dial(tlsCfg *tls.Config, req dialRequest) (messageConn, http.Header, error) {
+	stream, err := t.c.openHTTP2Stream(tlsCfg, req.host, req.port, req.path, func(r *http.Request) {
+		r.Header = t.c.bearerHeader(req)
+		r.Header.Set("Content-Type", "application/octet-stream")
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("xhttp 连接失败: %w", err)
+	}
+	return &xhttpConn{framedConn: newFramedConn(stream)}, stream.resp.Header, nil
+}
+
+type xhttpConn struct {
+	framedConn
+}
+
+func (x *xhttpConn) WriteMessage(messageType int, data []byte) error {
+	if messageType == websocket.PingMessage || messageType == websocket.PongMessage {
+		return nil
+	}
+	frame := make([]byte, 5, 5+len(data))
+	frame[0] = byte(messageType)
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(data)))
+	return x.writeFrame(append(frame, data...))
+}
+
+func (x *xhttpConn) ReadMessage() (int, []byte, error) {
+	header, payload, err := x.readFrame(5)
+	if err != nil {
+		return 0, nil, err
+	}
+	return int(header[0]), payload, nil
+}
@@ -0,0 +1,115 @@
+// timeouts.go - 超时、保活与重试退避参数
+package proxyclient
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"time"
+)
+
+// 默认超时与重试参数
+const (
+	defaultDialTimeout      = 10 * time.Second
+	defaultHandshakeTimeout = 10 * time.Second
+	defaultDNSTimeout       = 10 * time.Second
+	defaultInboundTimeout   = 30 * time.Second
+	defaultPingInterval     = 10 * time.Second
+	defaultPongTimeout      = 5 * time.Second
+	defaultKeepAlive        = 30 * time.Second
+	defaultMaxRetries       = 2
+	defaultRetryBackoff     = 500 * time.Millisecond
+	defaultRetryBackoffMax  = 5 * time.Second
+)
+
+// timeoutConfig 生效的超时与重试参数
+type timeoutConfig struct {
+	dial       time.Duration
+	handshake  time.Duration
+	dns        time.Duration
+	inbound    time.Duration
+	ping       time.Duration
+	pong       time.Duration // 0 表示不检测
+	idle       time.Duration
+	response   time.Duration // HTTP 正向代理等待响应头，0 表示不限制
+	keepAlive  time.Duration
+	maxRetries int
+	backoff    time.Duration
+	backoffMax time.Duration
+}
+
+// newTimeoutConfig 校验配置并为未设置的参数填充默认值
+func newTimeoutConfig(config Config) (timeoutConfig, error) {
+	durations := []struct {
+		name  string
+		value time.Duration
+	}{
+		{"DialTimeout", config.DialTimeout},
+		{"HandshakeTimeout", config.HandshakeTimeout},
+		{"DNSTimeout", config.DNSTimeout},
+		{"InboundTimeout", config.InboundTimeout},
+		{"PingInterval", config.PingInterval},
+		{"IdleTimeout", config.IdleTimeout},
+		{"ResponseHeaderTimeout", config.ResponseHeaderTimeout},
+		{"RetryBackoff", config.RetryBackoff},
+		{"RetryBackoffMax", config.RetryBackoffMax},
+	}
+	for _, d := range durations {
+		if d.value < 0 {
+			return timeoutConfig{}, fmt.Errorf("%s 不能为负数: %v", d.name, d.value)
+		}
+	}
+	if config.MaxRetries < 0 {
+		return timeoutConfig{}, fmt.Errorf("MaxRetries 不能为负数: %d", config.MaxRetries)
+	}
+
+	t := timeoutConfig{
+		dial:       orDefault(config.DialTimeout, defaultDialTimeout),
+		handshake:  orDefault(config.HandshakeTimeout, defaultHandshakeTimeout),
+		dns:        orDefault(config.DNSTimeout, defaultDNSTimeout),
+		inbound:    orDefault(config.InboundTimeout, defaultInboundTimeout),
+		ping:       orDefault(config.PingInterval, defaultPingInterval),
+		pong:       orDefault(config.PongTimeout, defaultPongTimeout),
+		idle:       config.IdleTimeout,
+		response:   config.ResponseHeaderTimeout,
+		keepAlive:  orDefault(config.KeepAlive, defaultKeepAlive),
+		maxRetries: config.MaxRetries,
+		backoff:    orDefault(config.RetryBackoff, defaultRetryBackoff),
+		backoffMax: orDefault(config.RetryBackoffMax, defaultRetryBackoffMax),
+	}
+	if t.pong < 0 {
+		t.pong = 0
+	}
+	if t.maxRetries == 0 {
+		t.maxRetries = defaultMaxRetries
+	}
+	if t.backoffMax < t.backoff {
+		t.backoffMax = t.backoff
+	}
+	return t, nil
+}
+
+func orDefault(d, def time.Duration) time.Duration {
+	if d == 0 {
+		return def
+	}
+	return d
+}
+
+// retryDelay 返回第 attempt 次失败后的等待时间：指数退避，并在后一半区间内随机抖动
+func (t timeoutConfig) retryDelay(attempt int) time.Duration {
+	d := t.backoff
+	for i := 1; i < attempt && d < t.backoffMax; i++ {
+		d *= 2
+	}
+	if d > t.backoffMax {
+		d = t.backoffMax
+	}
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(d-half)+1))
+}
+
+// dialer 返回出站连接使用的 net.Dialer
+func (t timeoutConfig) dialer() *net.Dialer {
+	return &net.Dialer{Timeout: t.dial, KeepAlive: t.keepAlive}
+}
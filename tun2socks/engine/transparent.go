@@ -0,0 +1,222 @@
+// transparent.go - 透明代理入站 (仅 Linux)
+//
+// 配合 iptables 使用，让整个局域网无需逐台配置代理：
+//
+//	REDIRECT: iptables -t nat -A PREROUTING -p tcp -j REDIRECT --to-ports 12345
+//	          通过 SO_ORIGINAL_DST 获取原始目标地址，只支持 TCP
+//	TPROXY:   iptables -t mangle -A PREROUTING -p tcp -j TPROXY --on-port 12345 --tproxy-mark 1
+//	          iptables -t mangle -A PREROUTING -p udp -j TPROXY --on-port 12345 --tproxy-mark 1
+//	          ip rule add fwmark 1 lookup 100 && ip route add local 0.0.0.0/0 dev lo table 100
+//	          套接字设置 IP_TRANSPARENT，本地地址即原始目标地址，同时支持 UDP (需要 CAP_NET_ADMIN)
+//
+// 在网关本机的 OUTPUT 链使用时，需排除本程序自身到服务端的连接，避免回环。
+package proxyclient
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 透明代理模式
+const (
+	TransparentRedirect = "redirect"
+	TransparentTProxy   = "tproxy"
+)
+
+const (
+	udpSessionTimeout = 60 * time.Second
+	udpQueueSize      = 64
+)
+
+func (c *ProxyClient) handleTransparentConn(conn net.Conn, in *inboundListener) {
+	clientAddr := conn.RemoteAddr().String()
+	mode := in.Protocol
+	target, err := originalDestination(conn, mode)
+	if err != nil {
+		c.logError("获取原始目标地址失败 %s: %v", clientAddr, err)
+		return
+	}
+	// REDIRECT 模式下直接连接监听端口的请求，其原始目标就是监听地址本身
+	if mode == TransparentRedirect && target == conn.LocalAddr().String() {
+		c.logError("拒绝非重定向的连接: %s", clientAddr)
+		return
+	}
+
+	src, ok := c.identifyApp(in.source(), IPProtoTCP, conn.RemoteAddr(), tcpAddr(target))
+	if !ok {
+		return
+	}
+
+	c.logInfo("透明代理 [%s]: %s -> %s", in.Tag, clientAddr, target)
+
+	if err := c.handleTunnel(conn, target, clientAddr, src, modeTransparent, nil); err != nil {
+		if !isNormalCloseError(err) {
+			c.logError("透明代理失败 %s: %v", clientAddr, err)
+		}
+	}
+}
+
+// tcpAddr 把 host:port 形式的 IP 地址转换为 *net.TCPAddr，无法解析时返回 nil
+func tcpAddr(addr string) net.Addr {
+	addrPort, err := netip.ParseAddrPort(addr)
+	if err != nil {
+		return nil
+	}
+	return net.TCPAddrFromAddrPort(addrPort)
+}
+
+// ======================== TPROXY UDP ========================
+
+// udpSession 一个 (客户端, 原始目标) 对应的 UDP 转发会话
+type udpSession struct {
+	queue      chan []byte
+	source     connSource
+	rejected   bool // 所属应用不允许使用代理，丢弃数据报直到会话空闲
+	lastActive atomic.Int64
+}
+
+func (s *udpSession) touch() {
+	s.lastActive.Store(time.Now().UnixNano())
+}
+
+func (s *udpSession) idle() time.Duration {
+	return time.Since(time.Unix(0, s.lastActive.Load()))
+}
+
+// serveTransparentUDP 读取 TPROXY 转来的数据报，按 (源地址, 原始目标) 分配会话
+func (c *ProxyClient) serveTransparentUDP(in *inboundListener) {
+	var mu sync.Mutex
+	sessions := make(map[string]*udpSession)
+
+	buf := make([]byte, 65535)
+	oob := make([]byte, 1024)
+	for {
+		n, src, dst, err := readTransparentUDP(in.udp, buf, oob)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) || !c.IsRunning() {
+				return
+			}
+			c.logError("透明代理读取 UDP 失败: %v", err)
+			continue
+		}
+
+		datagram := make([]byte, n)
+		copy(datagram, buf[:n])
+
+		// 会话只在持有 mu 时从表中删除，入队也在 mu 内完成，数据报不会进入已结束的会话
+		key := src.String() + "|" + dst.String()
+		mu.Lock()
+		session := sessions[key]
+		if session == nil {
+			session = &udpSession{queue: make(chan []byte, udpQueueSize)}
+			var ok bool
+			session.source, ok = c.identifyApp(in.source(), IPProtoUDP, net.UDPAddrFromAddrPort(src), net.UDPAddrFromAddrPort(dst))
+			session.rejected = !ok
+			session.touch()
+			sessions[key] = session
+			go func() {
+				for {
+					idle := false
+					if session.rejected {
+						waitUDPIdle(session)
+					} else {
+						idle = c.runUDPSession(session, src, dst)
+					}
+					// 空闲结束时恰好有新数据报入队：保留会话并重新建立隧道
+					mu.Lock()
+					restart := idle && len(session.queue) > 0
+					if !restart {
+						delete(sessions, key)
+					}
+					mu.Unlock()
+					if !restart {
+						return
+					}
+				}
+			}()
+		}
+		if session.rejected {
+			session.touch()
+		} else {
+			select {
+			case session.queue <- datagram:
+			default:
+				// 隧道尚未建立或发送过慢，丢弃
+			}
+		}
+		mu.Unlock()
+	}
+}
+
+// waitUDPIdle 等待被拒绝的会话空闲超时
+func waitUDPIdle(session *udpSession) {
+	ticker := time.NewTicker(udpSessionTimeout / 4)
+	defer ticker.Stop()
+	for range ticker.C {
+		if session.idle() >= udpSessionTimeout {
+			return
+		}
+	}
+}
+
+// runUDPSession 建立隧道并双向转发数据报，返回是否因空闲超时而结束
+func (c *ProxyClient) runUDPSession(session *udpSession, src, dst netip.AddrPort) bool {
+	target := dst.String()
+	relay, err := c.dialUDPRelay(target, session.source)
+	if err != nil {
+		c.logError("透明代理 UDP 失败 %s -> %s: %v", src, target, err)
+		return false
+	}
+	defer relay.Close()
+
+	// 回复数据报必须以原始目标地址为源地址发出
+	reply, err := listenUDPReply(dst)
+	if err != nil {
+		c.logError("透明代理 UDP 回复套接字创建失败 %s: %v", target, err)
+		return false
+	}
+	defer reply.Close()
+
+	c.logInfo("透明代理 UDP: %s -> %s", src, target)
+
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		clientAddr := net.UDPAddrFromAddrPort(src)
+		for {
+			datagram, err := relay.receive()
+			if err != nil {
+				return
+			}
+			session.touch()
+			if _, err := reply.WriteTo(datagram, clientAddr); err != nil {
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(udpSessionTimeout / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case datagram := <-session.queue:
+			session.touch()
+			if err := relay.send(datagram); err != nil {
+				return false
+			}
+		case <-ticker.C:
+			if !c.IsRunning() {
+				return false
+			}
+			if session.idle() >= udpSessionTimeout {
+				return true
+			}
+		case <-closed:
+			return false
+		}
+	}
+}
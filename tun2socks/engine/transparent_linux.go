@@ -0,0 +1,201 @@
+//go:build linux
+
+package proxyclient
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/netip"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// ip6tSOOriginalDst IPv6 的 SO_ORIGINAL_DST (linux/netfilter_ipv6/ip6_tables.h)
+const ip6tSOOriginalDst = 80
+
+// setTransparent 设置 IP_TRANSPARENT，允许接收/发送目标地址不属于本机的数据包
+func setTransparent(fd uintptr, ipv6 bool) error {
+	if ipv6 {
+		if err := unix.SetsockoptInt(int(fd), unix.SOL_IPV6, unix.IPV6_TRANSPARENT, 1); err != nil {
+			return err
+		}
+	}
+	// IPv6 套接字同样接收 IPv4 映射地址，两个选项都需要设置；纯 IPv6 套接字设置 IPv4 选项失败时忽略
+	if err := unix.SetsockoptInt(int(fd), unix.SOL_IP, unix.IP_TRANSPARENT, 1); err != nil && !ipv6 {
+		return err
+	}
+	return nil
+}
+
+func isIPv6Network(network string) bool {
+	return network == "tcp6" || network == "udp6"
+}
+
+// listenTransparentTCP 监听透明代理 TCP 端口，tproxy 模式需要设置 IP_TRANSPARENT
+func listenTransparentTCP(addr, mode string) (net.Listener, error) {
+	var lc net.ListenConfig
+	if mode == TransparentTProxy {
+		lc.Control = func(network, address string, c syscall.RawConn) error {
+			var sockErr error
+			err := c.Control(func(fd uintptr) {
+				sockErr = setTransparent(fd, isIPv6Network(network))
+			})
+			if err != nil {
+				return err
+			}
+			return sockErr
+		}
+	}
+	return lc.Listen(context.Background(), "tcp", addr)
+}
+
+// listenTransparentUDP 监听透明代理 UDP 端口，并通过控制消息获取每个数据报的原始目标地址
+func listenTransparentUDP(addr string) (*net.UDPConn, error) {
+	lc := net.ListenConfig{
+		Control: func(network, address string, c syscall.RawConn) error {
+			var sockErr error
+			err := c.Control(func(fd uintptr) {
+				ipv6 := isIPv6Network(network)
+				if sockErr = setTransparent(fd, ipv6); sockErr != nil {
+					return
+				}
+				if ipv6 {
+					if sockErr = unix.SetsockoptInt(int(fd), unix.SOL_IPV6, unix.IPV6_RECVORIGDSTADDR, 1); sockErr != nil {
+						return
+					}
+				}
+				if err := unix.SetsockoptInt(int(fd), unix.SOL_IP, unix.IP_RECVORIGDSTADDR, 1); err != nil && !ipv6 {
+					sockErr = err
+				}
+			})
+			if err != nil {
+				return err
+			}
+			return sockErr
+		},
+	}
+	conn, err := lc.ListenPacket(context.Background(), "udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return conn.(*net.UDPConn), nil
+}
+
+// originalDestination 返回被重定向连接的原始目标地址 (host:port)
+func originalDestination(conn net.Conn, mode string) (string, error) {
+	if mode == TransparentTProxy {
+		// TPROXY 不修改目标地址，本地地址即原始目标
+		return conn.LocalAddr().String(), nil
+	}
+
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return "", errors.New("不是 TCP 连接")
+	}
+	rawConn, err := tcpConn.SyscallConn()
+	if err != nil {
+		return "", err
+	}
+
+	ipv4 := true
+	if addr, ok := conn.LocalAddr().(*net.TCPAddr); ok && addr.IP.To4() == nil {
+		ipv4 = false
+	}
+
+	var dst netip.AddrPort
+	var sockErr error
+	err = rawConn.Control(func(fd uintptr) {
+		if ipv4 {
+			// SO_ORIGINAL_DST 返回 sockaddr_in，借用 IPv6Mreq (16 字节) 读取
+			var mreq *unix.IPv6Mreq
+			mreq, sockErr = unix.GetsockoptIPv6Mreq(int(fd), unix.SOL_IP, unix.SO_ORIGINAL_DST)
+			if sockErr != nil {
+				return
+			}
+			raw := mreq.Multiaddr
+			port := binary.BigEndian.Uint16(raw[2:4])
+			dst = netip.AddrPortFrom(netip.AddrFrom4([4]byte(raw[4:8])), port)
+			return
+		}
+		// IP6T_SO_ORIGINAL_DST 返回 sockaddr_in6，借用 IPv6MTUInfo 读取
+		var info *unix.IPv6MTUInfo
+		info, sockErr = unix.GetsockoptIPv6MTUInfo(int(fd), unix.SOL_IPV6, ip6tSOOriginalDst)
+		if sockErr != nil {
+			return
+		}
+		var portBytes [2]byte
+		binary.NativeEndian.PutUint16(portBytes[:], info.Addr.Port)
+		port := binary.BigEndian.Uint16(portBytes[:])
+		dst = netip.AddrPortFrom(netip.AddrFrom16(info.Addr.Addr).Unmap(), port)
+	})
+	if err != nil {
+		return "", err
+	}
+	if sockErr != nil {
+		return "", sockErr
+	}
+	return dst.String(), nil
+}
+
+// readTransparentUDP 读取一个数据报，返回源地址和原始目标地址
+func readTransparentUDP(conn *net.UDPConn, buf, oob []byte) (int, netip.AddrPort, netip.AddrPort, error) {
+	n, oobn, _, src, err := conn.ReadMsgUDPAddrPort(buf, oob)
+	if err != nil {
+		return 0, netip.AddrPort{}, netip.AddrPort{}, err
+	}
+
+	msgs, err := unix.ParseSocketControlMessage(oob[:oobn])
+	if err != nil {
+		return 0, netip.AddrPort{}, netip.AddrPort{}, err
+	}
+	for _, msg := range msgs {
+		switch {
+		case msg.Header.Level == unix.SOL_IP && msg.Header.Type == unix.IP_RECVORIGDSTADDR && len(msg.Data) >= 8:
+			// sockaddr_in: family(2) port(2) addr(4)
+			port := binary.BigEndian.Uint16(msg.Data[2:4])
+			dst := netip.AddrPortFrom(netip.AddrFrom4([4]byte(msg.Data[4:8])), port)
+			return n, unmapAddrPort(src), dst, nil
+		case msg.Header.Level == unix.SOL_IPV6 && msg.Header.Type == unix.IPV6_RECVORIGDSTADDR && len(msg.Data) >= 24:
+			// sockaddr_in6: family(2) port(2) flowinfo(4) addr(16)
+			port := binary.BigEndian.Uint16(msg.Data[2:4])
+			dst := netip.AddrPortFrom(netip.AddrFrom16([16]byte(msg.Data[8:24])).Unmap(), port)
+			return n, unmapAddrPort(src), dst, nil
+		}
+	}
+	return 0, netip.AddrPort{}, netip.AddrPort{}, errors.New("数据报缺少原始目标地址")
+}
+
+func unmapAddrPort(addr netip.AddrPort) netip.AddrPort {
+	return netip.AddrPortFrom(addr.Addr().Unmap(), addr.Port())
+}
+
+// listenUDPReply 创建绑定在原始目标地址上的套接字，用于以目标地址为源地址回复客户端
+func listenUDPReply(dst netip.AddrPort) (*net.UDPConn, error) {
+	network := "udp4"
+	if dst.Addr().Is6() {
+		network = "udp6"
+	}
+	lc := net.ListenConfig{
+		Control: func(network, address string, c syscall.RawConn) error {
+			var sockErr error
+			err := c.Control(func(fd uintptr) {
+				if sockErr = setTransparent(fd, isIPv6Network(network)); sockErr != nil {
+					return
+				}
+				sockErr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEADDR, 1)
+			})
+			if err != nil {
+				return err
+			}
+			return sockErr
+		},
+	}
+	conn, err := lc.ListenPacket(context.Background(), network, dst.String())
+	if err != nil {
+		return nil, err
+	}
+	return conn.(*net.UDPConn), nil
+}
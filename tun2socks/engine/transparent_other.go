@@ -0,0 +1,31 @@
+//go:build !linux
+
+package proxyclient
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+)
+
+var errTransparentUnsupported = errors.New("透明代理仅支持 Linux")
+
+func listenTransparentTCP(addr, mode string) (net.Listener, error) {
+	return nil, errTransparentUnsupported
+}
+
+func listenTransparentUDP(addr string) (*net.UDPConn, error) {
+	return nil, errTransparentUnsupported
+}
+
+func originalDestination(conn net.Conn, mode string) (string, error) {
+	return "", errTransparentUnsupported
+}
+
+func readTransparentUDP(conn *net.UDPConn, buf, oob []byte) (int, netip.AddrPort, netip.AddrPort, error) {
+	return 0, netip.AddrPort{}, netip.AddrPort{}, errTransparentUnsupported
+}
+
+func listenUDPReply(dst netip.AddrPort) (*net.UDPConn, error) {
+	return nil, errTransparentUnsupported
+}
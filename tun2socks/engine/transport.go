@@ -0,0 +1,520 @@
+// transport.go - 隧道传输层: WebSocket / gRPC / xhttp，按配置顺序协商并自动回退
+package proxyclient
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	utls "github.com/refraction-networking/utls"
+	"golang.org/x/net/http2"
+)
+
+// 隧道传输方式
+const (
+	TransportWebSocket = "websocket" // WebSocket (默认)，承载方式由 WebSocketTransport 决定
+	TransportGRPC      = "grpc"      // gRPC 双向流 (HTTP/2)
+	TransportXHTTP     = "xhttp"     // HTTP/2 流式 POST，请求体与响应体分别承载上下行
+)
+
+// transportCooldown 传输方式协商失败后，在此期间直接使用下一种方式
+const transportCooldown = 5 * time.Minute
+
+// messageConn 隧道使用的消息连接，消息类型沿用 WebSocket 的定义
+type messageConn interface {
+	WriteMessage(messageType int, data []byte) error
+	ReadMessage() (messageType int, p []byte, err error)
+	Close() error
+}
+
+// dialRequest 建立隧道连接的参数
+type dialRequest struct {
+	host, port, path string
+	protocols        []string // 提供的 WebSocket 子协议，按优先级排列
+}
+
+// offersV1 是否提供了 v1 隧道协议
+func (r dialRequest) offersV1() bool {
+	for _, protocol := range r.protocols {
+		if protocol == ProtocolV1Subprotocol {
+			return true
+		}
+	}
+	return false
+}
+
+// tunnelTransport 在 TLS 配置之上建立隧道消息连接，返回连接和服务端响应头
+type tunnelTransport interface {
+	dial(tlsCfg *tls.Config, req dialRequest) (messageConn, http.Header, error)
+}
+
+// handshakeError 服务端以非成功状态码拒绝了隧道握手
+type handshakeError struct {
+	statusCode int
+	status     string
+}
+
+func (e *handshakeError) Error() string {
+	return "服务端拒绝握手: " + e.status
+}
+
+// authRejected 是否为身份验证失败，此时更换传输方式没有意义
+func (e *handshakeError) authRejected() bool {
+	return e.statusCode == http.StatusUnauthorized || e.statusCode == http.StatusForbidden
+}
+
+// transportState 保存共享的 HTTP/2、HTTP/3 连接及各传输方式的失败记录
+type transportState struct {
+	mu     sync.Mutex
+	failed map[string]time.Time
+	h2     *http2.ClientConn
+	h3     *h3Session
+
+	// 正在建立的共享连接。拨号在锁外进行，同一时间只有一个调用方拨号，其余等待结果
+	h2Dial *sharedDial
+	h3Dial *sharedDial
+	// closeTransports 时递增，关闭前发起的拨号完成后直接丢弃
+	generation uint64
+}
+
+// sharedDial 进行中的共享连接拨号
+type sharedDial struct {
+	done chan struct{}
+	err  error
+}
+
+func newSharedDial() *sharedDial {
+	return &sharedDial{done: make(chan struct{})}
+}
+
+// finish 记录拨号结果并唤醒等待者
+func (d *sharedDial) finish(err error) {
+	d.err = err
+	close(d.done)
+}
+
+// wait 等待拨号完成，返回拨号错误
+func (d *sharedDial) wait() error {
+	<-d.done
+	return d.err
+}
+
+// errTransportsClosed 拨号期间共享连接被关闭 (停止服务或网络切换)
+var errTransportsClosed = errors.New("共享连接已关闭")
+
+// parseTransports 解析逗号分隔的传输方式列表
+func parseTransports(list string) ([]string, error) {
+	transports := splitList(list)
+	if len(transports) == 0 {
+		return []string{TransportWebSocket}, nil
+	}
+	for _, transport := range transports {
+		switch transport {
+		case TransportWebSocket, TransportGRPC, TransportXHTTP:
+		default:
+			return nil, fmt.Errorf("未知的传输方式: %s", transport)
+		}
+	}
+	return transports, nil
+}
+
+// transportFor 返回传输方式对应的实现
+func (c *ProxyClient) transportFor(name string) tunnelTransport {
+	switch name {
+	case WebSocketHTTP3:
+		return wsHTTP3Transport{c}
+	case WebSocketHTTP2:
+		return wsHTTP2Transport{c}
+	case TransportGRPC:
+		return grpcTransport{c}
+	case TransportXHTTP:
+		return xhttpTransport{c}
+	}
+	return wsHTTP1Transport{c}
+}
+
+// transportOrder 返回本次尝试的传输方式顺序，跳过近期失败的方式，最后一种始终保留
+func (c *ProxyClient) transportOrder() []string {
+	var candidates []string
+	for _, transport := range c.transportList {
+		if transport != TransportWebSocket {
+			candidates = append(candidates, transport)
+			continue
+		}
+		switch c.wsTransport {
+		case WebSocketHTTP3:
+			// QUIC 无法经由上游代理
+			if c.upstream == nil {
+				candidates = append(candidates, WebSocketHTTP3)
+			}
+			candidates = append(candidates, WebSocketHTTP2)
+		case WebSocketHTTP2:
+			candidates = append(candidates, WebSocketHTTP2)
+		}
+		candidates = append(candidates, WebSocketHTTP1)
+	}
+
+	c.transports.mu.Lock()
+	defer c.transports.mu.Unlock()
+	result := make([]string, 0, len(candidates))
+	for i, transport := range candidates {
+		if failedAt, ok := c.transports.failed[transport]; ok && time.Since(failedAt) < transportCooldown && i < len(candidates)-1 {
+			continue
+		}
+		result = append(result, transport)
+	}
+	return result
+}
+
+// failedRecently 判断传输方式 (或协议版本) 是否在冷却期内失败过
+func (c *ProxyClient) failedRecently(key string) bool {
+	c.transports.mu.Lock()
+	defer c.transports.mu.Unlock()
+	failedAt, ok := c.transports.failed[key]
+	return ok && time.Since(failedAt) < transportCooldown
+}
+
+func (c *ProxyClient) markTransportFailed(transport string) {
+	c.transports.mu.Lock()
+	defer c.transports.mu.Unlock()
+	if c.transports.failed == nil {
+		c.transports.failed = make(map[string]time.Time)
+	}
+	c.transports.failed[transport] = time.Now()
+}
+
+// closeTransports 关闭共享的 HTTP/2、HTTP/3 连接
+func (c *ProxyClient) closeTransports() {
+	c.transports.mu.Lock()
+	defer c.transports.mu.Unlock()
+	c.transports.generation++
+	if c.transports.h2 != nil {
+		c.transports.h2.Close()
+		c.transports.h2 = nil
+	}
+	if c.transports.h3 != nil {
+		c.transports.h3.close()
+		c.transports.h3 = nil
+	}
+}
+
+// dialTransports 按顺序尝试各传输方式建立隧道连接，返回连接和响应头。
+// ECH 被拒绝时直接返回，由调用方处理 retry_configs。
+func (c *ProxyClient) dialTransports(tlsCfg *tls.Config, req dialRequest) (messageConn, http.Header, error) {
+	order := c.transportOrder()
+	var lastErr error
+	for i, transport := range order {
+		conn, respHeader, err := c.transportFor(transport).dial(tlsCfg, req)
+		if err == nil {
+			return conn, respHeader, nil
+		}
+		var hsErr *handshakeError
+		if errors.As(err, &hsErr) && hsErr.authRejected() {
+			return nil, nil, err
+		}
+		if _, rejected := echRejection(err); rejected || i == len(order)-1 {
+			return nil, nil, err
+		}
+		c.markTransportFailed(transport)
+		c.logError("%s 传输不可用，回退到下一种方式: %v", transport, err)
+		lastErr = err
+	}
+	return nil, nil, lastErr
+}
+
+// ======================== 共享 HTTP/2 连接 ========================
+
+// dialServerTLS 建立到服务端的 TLS 连接并协商指定的 ALPN
+func (c *ProxyClient) dialServerTLS(ctx context.Context, tlsCfg *tls.Config, alpn []string, addr string) (net.Conn, error) {
+	if c.fingerprint != FingerprintGo {
+		return c.dialServerUTLS(tlsCfg, alpn)(ctx, "tcp", addr)
+	}
+
+	rawConn, err := c.dialServer(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	cfg := tlsCfg.Clone()
+	cfg.NextProtos = alpn
+	tlsConn := tls.Client(rawConn, cfg)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		rawConn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+// negotiatedALPN 返回 TLS 握手协商的 ALPN 协议
+func negotiatedALPN(conn net.Conn) string {
+	switch tlsConn := conn.(type) {
+	case *tls.Conn:
+		return tlsConn.ConnectionState().NegotiatedProtocol
+	case *utls.UConn:
+		return tlsConn.ConnectionState().NegotiatedProtocol
+	}
+	return ""
+}
+
+// http2Conn 返回共享的 HTTP/2 连接，不可用时重新建立
+func (c *ProxyClient) http2Conn(tlsCfg *tls.Config, host, port string) (*http2.ClientConn, error) {
+	for {
+		c.transports.mu.Lock()
+		if cc := c.transports.h2; cc != nil && cc.CanTakeNewRequest() {
+			c.transports.mu.Unlock()
+			return cc, nil
+		}
+		if pending := c.transports.h2Dial; pending != nil {
+			c.transports.mu.Unlock()
+			if err := pending.wait(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		pending := newSharedDial()
+		c.transports.h2Dial = pending
+		generation := c.transports.generation
+		c.transports.mu.Unlock()
+
+		cc, err := c.dialHTTP2(tlsCfg, host, port)
+
+		c.transports.mu.Lock()
+		c.transports.h2Dial = nil
+		if err == nil && generation != c.transports.generation {
+			cc.Close()
+			cc, err = nil, errTransportsClosed
+		}
+		if err == nil {
+			if c.transports.h2 != nil {
+				c.transports.h2.Close()
+			}
+			c.transports.h2 = cc
+		}
+		c.transports.mu.Unlock()
+		pending.finish(err)
+		return cc, err
+	}
+}
+
+// dialHTTP2 建立新的 HTTP/2 连接
+func (c *ProxyClient) dialHTTP2(tlsCfg *tls.Config, host, port string) (*http2.ClientConn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeouts.handshake)
+	defer cancel()
+	tlsConn, err := c.dialServerTLS(ctx, tlsCfg, []string{http2.NextProtoTLS}, net.JoinHostPort(host, port))
+	if err != nil {
+		return nil, err
+	}
+	if proto := negotiatedALPN(tlsConn); proto != http2.NextProtoTLS {
+		tlsConn.Close()
+		return nil, fmt.Errorf("服务端不支持 HTTP/2 (ALPN: %q)", proto)
+	}
+
+	echOK, resumed := handshakeState(tlsConn)
+	c.recordECHState(echOK)
+	c.recordHandshake(resumed)
+
+	transport := &http2.Transport{
+		ReadIdleTimeout: c.timeouts.ping,
+		PingTimeout:     c.timeouts.handshake,
+	}
+	cc, err := transport.NewClientConn(tlsConn)
+	if err != nil {
+		tlsConn.Close()
+		return nil, err
+	}
+	return cc, nil
+}
+
+// h2Stream 共享 HTTP/2 连接上的一个双向流：请求体上行，响应体下行
+type h2Stream struct {
+	resp   *http.Response
+	body   io.ReadCloser
+	pw     *io.PipeWriter
+	cancel context.CancelFunc
+}
+
+func (s *h2Stream) Read(p []byte) (int, error)  { return s.body.Read(p) }
+func (s *h2Stream) Write(p []byte) (int, error) { return s.pw.Write(p) }
+
+func (s *h2Stream) Close() error {
+	s.pw.Close()
+	s.body.Close()
+	s.cancel()
+	return nil
+}
+
+// openHTTP2Stream 在共享 HTTP/2 连接上发起流式请求，prepare 用于设置请求方法和头部
+func (c *ProxyClient) openHTTP2Stream(tlsCfg *tls.Config, host, port, path string, prepare func(req *http.Request)) (*h2Stream, error) {
+	cc, err := c.http2Conn(tlsCfg, host, port)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	pr, pw := io.Pipe()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://"+net.JoinHostPort(host, port)+path, pr)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	prepare(req)
+
+	timer := time.AfterFunc(c.timeouts.handshake, cancel)
+	resp, err := cc.RoundTrip(req)
+	if !timer.Stop() && err == nil {
+		resp.Body.Close()
+		err = errors.New("等待服务端响应超时")
+	}
+	if err != nil {
+		cancel()
+		pw.Close()
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		cancel()
+		pw.Close()
+		return nil, &handshakeError{statusCode: resp.StatusCode, status: resp.Status}
+	}
+
+	return &h2Stream{resp: resp, body: resp.Body, pw: pw, cancel: cancel}, nil
+}
+
+// ======================== 隧道连接 ========================
+
+// tunnelConn 隧道连接，按协商的协议版本收发消息
+type tunnelConn struct {
+	conn    messageConn
+	version string
+	writeMu sync.Mutex
+
+	owner       *tunnelSet
+	pongTimeout time.Duration // 0 表示连接不支持 Pong 或未启用检测
+	pingSent    atomic.Int64  // 尚未收到 Pong 的 Ping 发送时间 (UnixNano)，0 表示没有
+	readSince   atomic.Int64  // 本次 ReadMessage 开始等待的时间 (UnixNano)，0 表示不在读取
+	pongMissed  atomic.Bool
+
+	done      chan struct{} // 隧道关闭时关闭，用于中断限速等待
+	closeOnce sync.Once
+}
+
+// pongReceiver 支持 Pong 回调的消息连接 (WebSocket)
+type pongReceiver interface {
+	SetPongHandler(h func(appData string) error)
+}
+
+// newTunnelConn 包装已建立的消息连接并登记为活动隧道。WebSocket 连接在 Ping 后
+// pongTimeout 内未收到 Pong 时自动关闭；gRPC/xhttp 由 HTTP/2 PING 检测断线。
+func (c *ProxyClient) newTunnelConn(conn messageConn, version string) *tunnelConn {
+	t := &tunnelConn{conn: conn, version: version, owner: &c.tunnels, done: make(chan struct{})}
+	if receiver, ok := conn.(pongReceiver); ok && c.timeouts.pong > 0 {
+		t.pongTimeout = c.timeouts.pong
+		receiver.SetPongHandler(func(string) error {
+			t.pingSent.Store(0)
+			return nil
+		})
+	}
+	c.tunnels.add(t)
+	return t
+}
+
+func (t *tunnelConn) send(msg TunnelMessage) error {
+	messageType, data, err := EncodeTunnelMessage(t.version, msg)
+	if err != nil {
+		return err
+	}
+	t.writeMu.Lock()
+	defer t.writeMu.Unlock()
+	return t.conn.WriteMessage(messageType, data)
+}
+
+// receive 读取下一条消息。Pong 只在 ReadMessage 中处理，收到任何消息同样说明连接存活
+func (t *tunnelConn) receive() (TunnelMessage, error) {
+	t.readSince.Store(time.Now().UnixNano())
+	messageType, data, err := t.conn.ReadMessage()
+	t.readSince.Store(0)
+	if err != nil {
+		return TunnelMessage{}, err
+	}
+	t.pingSent.Store(0)
+	return DecodeTunnelMessage(t.version, messageType, data)
+}
+
+// ping 发送 Ping。上一个 Ping 仍在等待 Pong 时不重新计时。
+// 只有 Ping 发出后读取一直在等待、却仍未收到 Pong 时才关闭隧道；读取方忙于投递数据
+// (写本地连接或限速等待) 时 Pong 无法被处理，本次不计超时，由下一个 Ping 重新计时。
+func (t *tunnelConn) ping() error {
+	if t.pongTimeout > 0 {
+		sent := time.Now().UnixNano()
+		if t.pingSent.CompareAndSwap(0, sent) {
+			time.AfterFunc(t.pongTimeout, func() {
+				if since := t.readSince.Load(); since == 0 || since > sent {
+					t.pingSent.CompareAndSwap(sent, 0)
+					return
+				}
+				if t.pingSent.Load() == sent {
+					t.pongMissed.Store(true)
+					t.Close()
+				}
+			})
+		}
+	}
+	t.writeMu.Lock()
+	defer t.writeMu.Unlock()
+	return t.conn.WriteMessage(websocket.PingMessage, nil)
+}
+
+// pongTimedOut 隧道是否因等待 Pong 超时而被关闭
+func (t *tunnelConn) pongTimedOut() bool {
+	return t.pongMissed.Load()
+}
+
+func (t *tunnelConn) Close() error {
+	if t.owner != nil {
+		t.owner.remove(t)
+	}
+	t.closeOnce.Do(func() { close(t.done) })
+	return t.conn.Close()
+}
+
+// offeredProtocol 返回本次连接提供的最高协议版本
+func (c *ProxyClient) offeredProtocol() string {
+	switch c.protocol {
+	case ProtocolV1:
+		return ProtocolV1
+	case ProtocolAuto:
+		if !c.failedRecently(ProtocolV1) {
+			return ProtocolV1
+		}
+	}
+	return ProtocolV0
+}
+
+// newDialRequest 生成建立隧道的参数
+func (c *ProxyClient) newDialRequest(host, port, path, version string) dialRequest {
+	req := dialRequest{host: host, port: port, path: path}
+	if version == ProtocolV1 {
+		req.protocols = append(req.protocols, ProtocolV1Subprotocol)
+	}
+	if c.token != "" {
+		req.protocols = append(req.protocols, c.token)
+	}
+	return req
+}
+
+// negotiatedProtocol 根据服务端响应头确定协商的协议版本
+func negotiatedProtocol(offered string, respHeader http.Header) string {
+	if offered == ProtocolV1 &&
+		(respHeader.Get("Sec-WebSocket-Protocol") == ProtocolV1Subprotocol ||
+			respHeader.Get(TunnelProtocolHeader) == ProtocolV1Subprotocol) {
+		return ProtocolV1
+	}
+	return ProtocolV0
+}
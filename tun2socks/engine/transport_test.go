@@ -0,0 +1,88 @@
+package proxyclient
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// silentConn 从不回复 Pong 的消息连接，ReadMessage 阻塞直到有消息或连接关闭
+type silentConn struct {
+	messages  chan []byte
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newSilentConn() *silentConn {
+	return &silentConn{messages: make(chan []byte, 1), closed: make(chan struct{})}
+}
+
+func (c *silentConn) WriteMessage(messageType int, data []byte) error { return nil }
+
+func (c *silentConn) ReadMessage() (int, []byte, error) {
+	select {
+	case data := <-c.messages:
+		return websocket.BinaryMessage, data, nil
+	case <-c.closed:
+		return 0, nil, errors.New("closed")
+	}
+}
+
+func (c *silentConn) Close() error {
+	c.closeOnce.Do(func() { close(c.closed) })
+	return nil
+}
+
+func (c *silentConn) isClosed() bool {
+	select {
+	case <-c.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestPongTimeout(t *testing.T) {
+	const timeout = 50 * time.Millisecond
+
+	// 读取一直在等待却收不到 Pong：关闭隧道
+	conn := newSilentConn()
+	tunnel := &tunnelConn{conn: conn, version: ProtocolV1, pongTimeout: timeout, done: make(chan struct{})}
+	go tunnel.receive()
+	time.Sleep(10 * time.Millisecond)
+	tunnel.ping()
+	time.Sleep(3 * timeout)
+	if !conn.isClosed() || !tunnel.pongTimedOut() {
+		t.Error("读取等待中未收到 Pong，隧道应被关闭")
+	}
+
+	// 读取方忙于投递数据 (不在 ReadMessage 中)：不计超时
+	conn = newSilentConn()
+	tunnel = &tunnelConn{conn: conn, version: ProtocolV1, pongTimeout: timeout, done: make(chan struct{})}
+	tunnel.ping()
+	time.Sleep(3 * timeout)
+	if conn.isClosed() || tunnel.pongTimedOut() {
+		t.Error("读取方忙于投递数据时不应因 Pong 超时关闭隧道")
+	}
+
+	// 收到数据说明连接存活，下一个 Ping 重新计时
+	conn.messages <- encodeFrame(t, TunnelMessage{Type: FrameData, Payload: binaryPayload})
+	if _, err := tunnel.receive(); err != nil {
+		t.Fatal(err)
+	}
+	if tunnel.pingSent.Load() != 0 {
+		t.Error("收到消息后应清除等待中的 Ping")
+	}
+}
+
+func encodeFrame(t *testing.T, msg TunnelMessage) []byte {
+	t.Helper()
+	frame, err := encodeV1(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return frame
+}
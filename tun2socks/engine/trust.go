@@ -0,0 +1,175 @@
+// trust.go - 自定义CA、证书公钥固定 (SPKI pinning) 与验证域名
+package proxyclient
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// loadRootCAs 根据配置构建根证书池，未配置自定义CA时返回 nil (使用系统默认)
+func loadRootCAs(config Config) (*x509.CertPool, error) {
+	var bundle []byte
+	if config.CACertFile != "" {
+		data, err := os.ReadFile(config.CACertFile)
+		if err != nil {
+			return nil, fmt.Errorf("读取CA证书文件失败: %w", err)
+		}
+		bundle = append(bundle, data...)
+		bundle = append(bundle, '\n')
+	}
+	bundle = append(bundle, config.CACertPEM...)
+	if len(bytes.TrimSpace(bundle)) == 0 {
+		return nil, nil
+	}
+
+	pool := x509.NewCertPool()
+	if !config.CAOnly {
+		// 部分 Android 版本无法加载系统根证书，此时只使用自定义CA
+		if system, err := x509.SystemCertPool(); err == nil {
+			pool = system
+		}
+	}
+	if !pool.AppendCertsFromPEM(bundle) {
+		return nil, errors.New("CA证书中没有有效的 PEM 证书")
+	}
+	return pool, nil
+}
+
+// parseSPKIPins 解析证书公钥固定值，支持 "sha256/<base64>"、base64 或十六进制格式的 SHA-256
+func parseSPKIPins(pins []string) ([][]byte, error) {
+	var result [][]byte
+	for _, pin := range pins {
+		pin = strings.TrimSpace(pin)
+		if pin == "" {
+			continue
+		}
+		value := strings.TrimPrefix(pin, "sha256/")
+		hash, err := base64.StdEncoding.DecodeString(value)
+		if err != nil || len(hash) != sha256.Size {
+			hash, err = hex.DecodeString(strings.ReplaceAll(value, ":", ""))
+		}
+		if err != nil || len(hash) != sha256.Size {
+			return nil, fmt.Errorf("无效的证书公钥固定值: %s", pin)
+		}
+		result = append(result, hash)
+	}
+	return result, nil
+}
+
+// spkiHash 计算证书 SubjectPublicKeyInfo 的 SHA-256
+func spkiHash(cert *x509.Certificate) []byte {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return sum[:]
+}
+
+// matchPins 证书中任意一张的公钥匹配固定值即通过
+func matchPins(certs []*x509.Certificate, pins [][]byte) bool {
+	for _, cert := range certs {
+		hash := spkiHash(cert)
+		for _, pin := range pins {
+			if bytes.Equal(hash, pin) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// applyServerTrust 为连接服务端的 TLS 配置设置根证书、公钥固定和验证域名
+func (c *ProxyClient) applyServerTrust(cfg *tls.Config) {
+	cfg.RootCAs = c.rootCAs
+	if len(c.serverPins) == 0 && (c.verifyName == "" || c.verifyName == cfg.ServerName) {
+		return
+	}
+
+	verifyName := c.verifyName
+	if verifyName == "" {
+		verifyName = cfg.ServerName
+	}
+	roots := c.rootCAs
+	pins := c.serverPins
+
+	// 验证域名与 SNI 不同时无法使用内置验证，改为在 VerifyConnection 中完成。
+	// 与 VerifyPeerCertificate 不同，VerifyConnection 在会话复用时也会调用，
+	// 固定值或验证域名变更后，按旧配置缓存的会话同样需要重新通过验证。
+	cfg.InsecureSkipVerify = true
+	cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+		certs := cs.PeerCertificates
+		if len(certs) == 0 {
+			return errors.New("服务端未提供证书")
+		}
+
+		opts := x509.VerifyOptions{
+			Roots:         roots,
+			DNSName:       verifyName,
+			Intermediates: x509.NewCertPool(),
+		}
+		for _, cert := range certs[1:] {
+			opts.Intermediates.AddCert(cert)
+		}
+		chains, verifyErr := certs[0].Verify(opts)
+
+		if len(pins) == 0 {
+			if verifyErr != nil {
+				return fmt.Errorf("证书验证失败: %w", verifyErr)
+			}
+			return nil
+		}
+		if verifyErr == nil {
+			for _, chain := range chains {
+				if matchPins(chain, pins) {
+					return nil
+				}
+			}
+			return errors.New("服务端证书链不匹配固定的公钥")
+		}
+		// 证书链无法验证 (如自签名证书) 时，只接受公钥被固定的叶子证书
+		if matchPins(certs[:1], pins) {
+			return nil
+		}
+		return fmt.Errorf("证书验证失败且公钥未被固定: %w", verifyErr)
+	}
+}
+
+// applyDNSTrust 为 DoH 请求的 TLS 配置设置根证书和公钥固定
+func (c *ProxyClient) applyDNSTrust(cfg *tls.Config) {
+	cfg.RootCAs = c.rootCAs
+	if len(c.dnsPins) == 0 {
+		return
+	}
+	pins := c.dnsPins
+	verify := cfg.VerifyConnection
+	cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+		if verify != nil {
+			if err := verify(cs); err != nil {
+				return err
+			}
+		}
+		if !matchPins(cs.PeerCertificates, pins) {
+			return errors.New("DoH 服务器证书不匹配固定的公钥")
+		}
+		return nil
+	}
+}
+
+// CertificateSPKIPin 计算 PEM 证书的公钥固定值 (sha256/<base64>)
+func CertificateSPKIPin(certPEM string) (string, error) {
+	block, _ := pem.Decode([]byte(certPEM))
+	if block == nil {
+		return "", errors.New("无效的 PEM 证书")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return "", fmt.Errorf("解析证书失败: %w", err)
+	}
+	return "sha256/" + base64.StdEncoding.EncodeToString(spkiHash(cert)), nil
+}
@@ -0,0 +1,112 @@
+// udprelay.go - 经隧道转发 UDP 数据报
+//
+// v1 协议通过 UDP 帧建立关联，之后每条 DATA 消息对应一个数据报。服务端只支持 v0 时，
+// 发往 53 端口的 DNS 查询改为经 TCP 隧道按 DNS over TCP (RFC 7766) 转发，其它 UDP 流量无法转发。
+package proxyclient
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+)
+
+// udpRelay 经隧道收发数据报
+type udpRelay interface {
+	send(datagram []byte) error
+	receive() ([]byte, error)
+	Close() error
+}
+
+// dialUDPRelay 为来自 src 的流量建立到 target 的 UDP 转发
+func (c *ProxyClient) dialUDPRelay(target string, src connSource) (udpRelay, error) {
+	if err := c.checkBlocked(target, src); err != nil {
+		return nil, err
+	}
+	tunnel, err := c.dialTunnel(c.timeouts.maxRetries)
+	if err != nil {
+		return nil, err
+	}
+
+	if tunnel.version == ProtocolV1 {
+		if err := connectTarget(tunnel, TunnelMessage{Type: FrameUDP, Target: target}); err != nil {
+			tunnel.Close()
+			return nil, err
+		}
+		return &datagramRelay{tunnel: tunnel, limiter: c.newConnLimiter(target, src)}, nil
+	}
+
+	if _, port, _ := net.SplitHostPort(target); port != "53" {
+		tunnel.Close()
+		return nil, newTunnelError(ErrorCodeUnknown, errors.New("服务端使用 v0 协议，只能转发 DNS 查询 (需使用 v1 或 auto 协议)"))
+	}
+	if err := connectTarget(tunnel, TunnelMessage{Type: FrameConnect, Target: target}); err != nil {
+		tunnel.Close()
+		return nil, err
+	}
+	return &dnsTCPRelay{conn: c.newTunnelStream(tunnel, target, src)}, nil
+}
+
+// datagramRelay v1 UDP 关联，每条 DATA 消息为一个数据报
+type datagramRelay struct {
+	tunnel  *tunnelConn
+	limiter *connLimiter
+}
+
+func (r *datagramRelay) send(datagram []byte) error {
+	r.limiter.waitUpload(len(datagram), r.tunnel.done)
+	return r.tunnel.send(TunnelMessage{Type: FrameData, Payload: datagram})
+}
+
+func (r *datagramRelay) receive() ([]byte, error) {
+	for {
+		msg, err := r.tunnel.receive()
+		if err != nil {
+			return nil, err
+		}
+		switch msg.Type {
+		case FrameData:
+			r.limiter.waitDownload(len(msg.Payload), r.tunnel.done)
+			return msg.Payload, nil
+		case FrameClose:
+			return nil, io.EOF
+		}
+	}
+}
+
+func (r *datagramRelay) Close() error {
+	r.tunnel.send(TunnelMessage{Type: FrameClose})
+	return r.tunnel.Close()
+}
+
+// dnsTCPRelay 把 DNS 查询转换为 DNS over TCP，每条消息前加 2 字节长度
+type dnsTCPRelay struct {
+	conn net.Conn
+}
+
+func (r *dnsTCPRelay) send(datagram []byte) error {
+	if len(datagram) > 0xffff {
+		return errors.New("DNS 消息过大")
+	}
+	msg := make([]byte, 2+len(datagram))
+	binary.BigEndian.PutUint16(msg, uint16(len(datagram)))
+	copy(msg[2:], datagram)
+	_, err := r.conn.Write(msg)
+	return err
+}
+
+func (r *dnsTCPRelay) receive() ([]byte, error) {
+	var length [2]byte
+	if _, err := io.ReadFull(r.conn, length[:]); err != nil {
+		return nil, err
+	}
+	msg := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(r.conn, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+func (r *dnsTCPRelay) Close() error {
+	return r.conn.Close()
+}
@@ -0,0 +1,191 @@
+// wsstream.go - 基于 HTTP/2、HTTP/3 流的 WebSocket 帧编解码 (RFC 6455 客户端)
+package proxyclient
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	wsOpContinuation = 0x0
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xA
+
+	wsMaxMessageSize = 16 << 20
+)
+
+// streamWSConn 在已建立的双向流 (扩展 CONNECT 的请求/响应体) 上收发 WebSocket 消息。
+// 扩展 CONNECT 本身即是 WebSocket 握手，流上直接承载 RFC 6455 帧。
+type streamWSConn struct {
+	r       *bufio.Reader
+	w       io.Writer
+	closeFn func() error
+
+	writeMu     sync.Mutex
+	closeOnce   sync.Once
+	pongHandler func(appData string) error
+}
+
+func newStreamWSConn(r io.Reader, w io.Writer, closeFn func() error) *streamWSConn {
+	return &streamWSConn{
+		r:       bufio.NewReaderSize(r, 32*1024),
+		w:       w,
+		closeFn: closeFn,
+	}
+}
+
+// WriteMessage 发送一条完整的消息，客户端帧必须加掩码
+func (s *streamWSConn) WriteMessage(messageType int, data []byte) error {
+	header := make([]byte, 2, 14)
+	header[0] = 0x80 | byte(messageType)
+	switch n := len(data); {
+	case n <= 125:
+		header[1] = 0x80 | byte(n)
+	case n <= 0xFFFF:
+		header[1] = 0x80 | 126
+		header = binary.BigEndian.AppendUint16(header, uint16(n))
+	default:
+		header[1] = 0x80 | 127
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	}
+
+	var key [4]byte
+	if _, err := rand.Read(key[:]); err != nil {
+		return err
+	}
+	header = append(header, key[:]...)
+
+	frame := make([]byte, len(header)+len(data))
+	copy(frame, header)
+	payload := frame[len(header):]
+	for i, b := range data {
+		payload[i] = b ^ key[i&3]
+	}
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	_, err := s.w.Write(frame)
+	return err
+}
+
+// ReadMessage 读取下一条数据消息，控制帧在内部处理
+func (s *streamWSConn) ReadMessage() (int, []byte, error) {
+	var messageType int
+	var message []byte
+	for {
+		fin, opcode, payload, err := s.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		switch opcode {
+		case wsOpPing:
+			if err := s.WriteMessage(websocket.PongMessage, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case wsOpPong:
+			if s.pongHandler != nil {
+				if err := s.pongHandler(string(payload)); err != nil {
+					return 0, nil, err
+				}
+			}
+			continue
+		case wsOpClose:
+			closeErr := &websocket.CloseError{Code: websocket.CloseNoStatusReceived}
+			if len(payload) >= 2 {
+				closeErr.Code = int(binary.BigEndian.Uint16(payload[:2]))
+				closeErr.Text = string(payload[2:])
+			}
+			s.WriteMessage(websocket.CloseMessage, payload[:min(len(payload), 2)])
+			return 0, nil, closeErr
+		case wsOpContinuation:
+			if messageType == 0 {
+				return 0, nil, errors.New("websocket: 意外的延续帧")
+			}
+		default:
+			if messageType != 0 {
+				return 0, nil, errors.New("websocket: 分片消息未结束")
+			}
+			messageType = int(opcode)
+		}
+
+		if len(message)+len(payload) > wsMaxMessageSize {
+			return 0, nil, errors.New("websocket: 消息过大")
+		}
+		message = append(message, payload...)
+		if fin {
+			return messageType, message, nil
+		}
+	}
+}
+
+func (s *streamWSConn) readFrame() (fin bool, opcode byte, payload []byte, err error) {
+	var head [2]byte
+	if _, err = io.ReadFull(s.r, head[:]); err != nil {
+		return
+	}
+	fin = head[0]&0x80 != 0
+	opcode = head[0] & 0x0F
+	masked := head[1]&0x80 != 0
+
+	length := uint64(head[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(s.r, ext[:]); err != nil {
+			return
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(s.r, ext[:]); err != nil {
+			return
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if length > wsMaxMessageSize {
+		err = fmt.Errorf("websocket: 帧过大: %d", length)
+		return
+	}
+
+	var key [4]byte
+	if masked {
+		if _, err = io.ReadFull(s.r, key[:]); err != nil {
+			return
+		}
+	}
+
+	payload = make([]byte, length)
+	if _, err = io.ReadFull(s.r, payload); err != nil {
+		return
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= key[i&3]
+		}
+	}
+	return
+}
+
+// SetPongHandler 设置收到 Pong 时的回调
+func (s *streamWSConn) SetPongHandler(h func(appData string) error) {
+	s.pongHandler = h
+}
+
+// Close 关闭底层流
+func (s *streamWSConn) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		err = s.closeFn()
+	})
+	return err
+}
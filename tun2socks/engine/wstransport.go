@@ -0,0 +1,239 @@
+// wstransport.go - WebSocket 承载方式: HTTP/1.1 Upgrade、HTTP/2 扩展 CONNECT (RFC 8441)、HTTP/3 (RFC 9220)
+package proxyclient
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// WebSocket 承载方式
+const (
+	WebSocketHTTP1 = "http1" // HTTP/1.1 Upgrade，每个隧道一个 TCP 连接 (默认)
+	WebSocketHTTP2 = "http2" // HTTP/2 扩展 CONNECT，所有隧道共享一个 TLS 连接
+	WebSocketHTTP3 = "http3" // HTTP/3 扩展 CONNECT (QUIC)，适合丢包较多的移动网络
+)
+
+// wsRequestHeader 生成扩展 CONNECT 请求的 WebSocket 头部
+func wsRequestHeader(req dialRequest) http.Header {
+	header := http.Header{"Sec-Websocket-Version": {"13"}}
+	if len(req.protocols) > 0 {
+		header.Set("Sec-WebSocket-Protocol", strings.Join(req.protocols, ", "))
+	}
+	return header
+}
+
+// ======================== HTTP/1.1 ========================
+
+type wsHTTP1Transport struct{ c *ProxyClient }
+
+func (t wsHTTP1Transport) dial(tlsCfg *tls.Config, req dialRequest) (messageConn, http.Header, error) {
+	c := t.c
+	dialer := websocket.Dialer{
+		TLSClientConfig:  tlsCfg,
+		Subprotocols:     req.protocols,
+		HandshakeTimeout: c.timeouts.handshake,
+		NetDialContext:   c.dialServer,
+	}
+
+	if c.fingerprint != FingerprintGo {
+		dialer.NetDialTLSContext = c.dialServerUTLS(tlsCfg, []string{"http/1.1"})
+	}
+
+	wsURL := fmt.Sprintf("wss://%s:%s%s", req.host, req.port, req.path)
+	wsConn, resp, err := dialer.Dial(wsURL, nil)
+	if err != nil {
+		if errors.Is(err, websocket.ErrBadHandshake) && resp != nil {
+			return nil, nil, &handshakeError{statusCode: resp.StatusCode, status: resp.Status}
+		}
+		return nil, nil, err
+	}
+
+	echOK, resumed := handshakeState(wsConn.UnderlyingConn())
+	c.recordECHState(echOK)
+	c.recordHandshake(resumed)
+	return wsConn, resp.Header, nil
+}
+
+// ======================== HTTP/2 ========================
+
+type wsHTTP2Transport struct{ c *ProxyClient }
+
+func (t wsHTTP2Transport) dial(tlsCfg *tls.Config, req dialRequest) (messageConn, http.Header, error) {
+	stream, err := t.c.openHTTP2Stream(tlsCfg, req.host, req.port, req.path, func(r *http.Request) {
+		r.Method = http.MethodConnect
+		r.Header = wsRequestHeader(req)
+		r.Header.Set(":protocol", "websocket")
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("HTTP/2 WebSocket 握手失败: %w", err)
+	}
+	return newStreamWSConn(stream, stream, stream.Close), stream.resp.Header, nil
+}
+
+// ======================== HTTP/3 ========================
+
+type h3Session struct {
+	pconn net.PacketConn
+	qconn *quic.Conn
+	cc    *http3.ClientConn
+}
+
+func (s *h3Session) close() {
+	s.qconn.CloseWithError(quic.ApplicationErrorCode(http3.ErrCodeNoError), "")
+	s.pconn.Close()
+}
+
+// http3Conn 返回共享的 HTTP/3 连接，不可用时重新建立。
+// QUIC 握手使用 crypto/tls，不应用浏览器指纹。
+func (c *ProxyClient) http3Conn(tlsCfg *tls.Config, host, port string) (*http3.ClientConn, error) {
+	for {
+		c.transports.mu.Lock()
+		if s := c.transports.h3; s != nil {
+			if s.qconn.Context().Err() == nil {
+				c.transports.mu.Unlock()
+				return s.cc, nil
+			}
+			s.close()
+			c.transports.h3 = nil
+		}
+		if pending := c.transports.h3Dial; pending != nil {
+			c.transports.mu.Unlock()
+			if err := pending.wait(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		pending := newSharedDial()
+		c.transports.h3Dial = pending
+		generation := c.transports.generation
+		c.transports.mu.Unlock()
+
+		session, err := c.dialHTTP3(tlsCfg, host, port)
+
+		c.transports.mu.Lock()
+		c.transports.h3Dial = nil
+		if err == nil && generation != c.transports.generation {
+			session.close()
+			session, err = nil, errTransportsClosed
+		}
+		if err == nil {
+			c.transports.h3 = session
+		}
+		c.transports.mu.Unlock()
+		pending.finish(err)
+		if err != nil {
+			return nil, err
+		}
+		return session.cc, nil
+	}
+}
+
+// dialHTTP3 建立新的 HTTP/3 连接
+func (c *ProxyClient) dialHTTP3(tlsCfg *tls.Config, host, port string) (*h3Session, error) {
+	addrHost := host
+	if c.serverIP != "" {
+		addrHost = c.serverIP
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeouts.handshake)
+	defer cancel()
+
+	udpAddr, err := c.resolveUDPAddr(ctx, addrHost, port)
+	if err != nil {
+		return nil, err
+	}
+	pconn, err := c.listenUDP()
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := tlsCfg.Clone()
+	cfg.NextProtos = []string{http3.NextProtoH3}
+
+	qconn, err := quic.Dial(ctx, pconn, udpAddr, cfg, &quic.Config{
+		KeepAlivePeriod: c.timeouts.ping,
+		MaxIdleTimeout:  60 * time.Second,
+	})
+	if err != nil {
+		pconn.Close()
+		return nil, err
+	}
+	session := &h3Session{pconn: pconn, qconn: qconn}
+
+	state := qconn.ConnectionState().TLS
+	c.recordECHState(state.ECHAccepted)
+	c.recordHandshake(state.DidResume)
+
+	session.cc = (&http3.Transport{DisableCompression: true}).NewClientConn(qconn)
+	select {
+	case <-session.cc.ReceivedSettings():
+	case <-ctx.Done():
+		session.close()
+		return nil, errors.New("等待 HTTP/3 SETTINGS 超时")
+	}
+	if !session.cc.Settings().EnableExtendedConnect {
+		session.close()
+		return nil, errors.New("服务端未启用 HTTP/3 扩展 CONNECT")
+	}
+	return session, nil
+}
+
+type wsHTTP3Transport struct{ c *ProxyClient }
+
+func (t wsHTTP3Transport) dial(tlsCfg *tls.Config, dreq dialRequest) (messageConn, http.Header, error) {
+	cc, err := t.c.http3Conn(tlsCfg, dreq.host, dreq.port)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	str, err := cc.OpenRequestStream(ctx)
+	if err != nil {
+		cancel()
+		return nil, nil, err
+	}
+	abort := func() {
+		str.CancelRead(quic.StreamErrorCode(http3.ErrCodeNoError))
+		str.CancelWrite(quic.StreamErrorCode(http3.ErrCodeNoError))
+		cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodConnect, "https://"+net.JoinHostPort(dreq.host, dreq.port)+dreq.path, nil)
+	if err != nil {
+		abort()
+		return nil, nil, err
+	}
+	req.Proto = "websocket"
+	req.Header = wsRequestHeader(dreq)
+
+	str.SetReadDeadline(time.Now().Add(t.c.timeouts.handshake))
+	if err := str.SendRequestHeader(req); err != nil {
+		abort()
+		return nil, nil, err
+	}
+	resp, err := str.ReadResponse()
+	if err != nil {
+		abort()
+		return nil, nil, err
+	}
+	str.SetReadDeadline(time.Time{})
+	if resp.StatusCode != http.StatusOK {
+		abort()
+		return nil, nil, fmt.Errorf("HTTP/3 WebSocket 握手失败: %w", &handshakeError{statusCode: resp.StatusCode, status: resp.Status})
+	}
+
+	conn := newStreamWSConn(str, str, func() error {
+		abort()
+		return nil
+	})
+	return conn, resp.Header, nil
+}